@@ -6,9 +6,10 @@ import (
 	"log/slog"
 	"os"
 	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
-	"github.com/a-h/templ"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/exporters/prometheus"
@@ -18,8 +19,15 @@ import (
 
 	"github.com/debugloop/wunschkonzert/pkg/api"
 	"github.com/debugloop/wunschkonzert/pkg/api/handlers"
+	"github.com/debugloop/wunschkonzert/pkg/assets"
 	"github.com/debugloop/wunschkonzert/pkg/auth"
+	"github.com/debugloop/wunschkonzert/pkg/catalog"
+	"github.com/debugloop/wunschkonzert/pkg/health"
+	"github.com/debugloop/wunschkonzert/pkg/i18n"
+	"github.com/debugloop/wunschkonzert/pkg/logging"
 	"github.com/debugloop/wunschkonzert/pkg/realtime"
+	"github.com/debugloop/wunschkonzert/pkg/requests"
+	"github.com/debugloop/wunschkonzert/pkg/search"
 	spotifylib "github.com/debugloop/wunschkonzert/pkg/spotify"
 	"github.com/debugloop/wunschkonzert/pkg/ui"
 )
@@ -28,6 +36,22 @@ func main() {
 	// App server settings.
 	serverName := flag.String("server.name", "http://localhost:8080", "The public address of the server. Used for CORS and the oauth redirect.")
 	serverListen := flag.String("server.listen", ":8080", "Where the app will be listening for the user-facing routes.")
+	assetsMode := flag.String("assets.mode", string(assets.ModeCDN), "Where scripts, styles and fonts are loaded from, either 'embedded' or 'cdn'.")
+
+	// Event branding.
+	event := &ui.Event{}
+	flag.StringVar(&event.Title, "event.title", "Wunschkonzert", "The title shown in the header and the browser tab.")
+	flag.StringVar(&event.Hosts, "event.hosts", "", "The hosts of the event, prominently shown in the header.")
+	flag.StringVar(&event.Subtitle, "event.subtitle", "", "A subtitle shown below the hosts, e.g. the date or venue.")
+	flag.StringVar(&event.Logo, "event.logo", "", "An http(s) URL or a local path to an image shown next to the title.")
+	flag.StringVar(&event.PrimaryColor, "event.color.primary", "", "The primary accent color as a hex code, e.g. #d81b60.")
+	flag.StringVar(&event.SecondaryColor, "event.color.secondary", "", "The secondary accent color as a hex code.")
+	flag.StringVar(&event.Theme, "event.theme", "", "Force either the 'light' or 'dark' theme instead of the browser's preference.")
+	flag.StringVar(&event.Font, "event.font", "Brittany Signature", "The font family the hosts are shown in.")
+	flag.StringVar(&event.FontURL, "event.font.url", "", "A stylesheet providing -event.font. The bundled fonts are used if empty.")
+	flag.StringVar(&event.Intro, "event.intro", "", "A text shown to guests above the search box.")
+	flag.StringVar(&event.Prompt, "event.prompt", "", "The placeholder of the search box. A translated default is used if empty.")
+	defaultLocale := flag.String("locale.default", "de", "The language used if none of the browser's preferred languages is available.")
 
 	// Spotify Authentication.
 	authListen := flag.String("auth.listen", ":8081", "Where the app will be listening for the admin's spotify login.")
@@ -36,28 +60,51 @@ func main() {
 	tokenPersistPath := flag.String("auth.token.path", "./token.json", "The path where a token will be persisted. May be empty in order to not persist tokens.")
 
 	// Spotify settings.
-	nowPlayingFrequency := flag.Duration("nowplaying.frequency", 1*time.Second, "The frequency of now playing info updates")
+	nowPlayingFrequency := flag.Duration("nowplaying.frequency", 1*time.Second, "The highest frequency of now playing info updates, used near the end of songs")
+	nowPlayingFrequencyMin := flag.Duration("nowplaying.frequency.min", 10*time.Second, "The lowest frequency of now playing info updates, used while nothing is about to change")
 	searchMarket := flag.String("search.market", "DE", "The market that searching is limited to")
 	searchLimit := flag.Uint("search.limit", 15, "The number of results that searching is limited to")
+	searchCacheSize := flag.Int("search.cache.size", 1000, "The number of search results kept in cache")
+	searchCacheTTL := flag.Duration("search.cache.ttl", 15*time.Minute, "How long search results are kept in cache")
 	playlistID := flag.String("playlist.id", "", "The ID of the playlist users can prepend to")
+	catalogPersistPath := flag.String("catalog.path", "./catalog.jsonl", "The path where the local catalog used when spotify is unreachable will be persisted. May be empty in order to not persist it.")
+	catalogPlaylists := flag.String("catalog.playlists", "", "A comma separated list of IDs or links of playlists or albums to prefetch into the local catalog, in addition to the playlist users prepend to")
+	catalogRefresh := flag.Duration("catalog.refresh", 1*time.Hour, "The frequency of prefetching playlists into the local catalog")
+	catalogRestrict := flag.String("catalog.restrict", "", "A comma separated list of links to playlists or albums. If given, users can only pick songs from these")
+	catalogRestrictPath := flag.String("catalog.restrict.path", "./pool.jsonl", "The path where the songs of -catalog.restrict will be persisted, so they are available before spotify is reached. May be empty in order to not persist them.")
+	browsePicks := flag.String("browse.picks", "", "A comma separated list of links to playlists or albums suggested to users before they search")
+	historyPersistPath := flag.String("history.path", "", "The path where requested songs will be persisted, including the names guests have given. Requests are not persisted if empty.")
 
 	// Observability.
-	metricsListen := flag.String("metrics.listen", ":9999", "Where the app will be exposing its metrics.")
-	verbose := flag.Bool("verbose", false, "Whether to be more verbose in logging")
+	metricsListen := flag.String("metrics.listen", ":9999", "Where the app will be exposing its metrics, health probes and status page.")
+	healthInterval := flag.Duration("health.interval", 30*time.Second, "The frequency of checking whether the app is functional")
+	tracingExporter := flag.String("tracing.exporter", "none", "Where traces are sent, either 'none', 'otlp', 'stderr' or 'file'.")
+	tracingEndpoint := flag.String("tracing.otlp.endpoint", "http://localhost:4318", "The URL of the OTLP collector traces are sent to, if -tracing.exporter is 'otlp'.")
+	tracingPath := flag.String("tracing.path", "./traces.jsonl", "The path traces are appended to, if -tracing.exporter is 'file'.")
+	tracingRatio := flag.Float64("tracing.ratio", 1, "The share of traces sampled, unless a caller has decided already.")
+	logFormat := flag.String("log.format", "text", "The format of log lines, either 'text' or 'json'.")
+	logLevel := flag.String("log.level", "info", "The level logged at, either 'debug', 'info', 'warn' or 'error'. Sending SIGUSR1 toggles debug logging at runtime.")
+	verbose := flag.Bool("verbose", false, "Whether to be more verbose in logging, same as -log.level=debug")
 
 	flag.Parse()
 
-	level := slog.LevelInfo
+	var baseLevel slog.Level
+	if err := baseLevel.UnmarshalText([]byte(*logLevel)); err != nil {
+		slog.Error("Invalid -log.level argument.", "error", err)
+		os.Exit(2)
+	}
 	if *verbose {
-		level = slog.LevelDebug
+		baseLevel = slog.LevelDebug
+	}
+	// The level can be changed at runtime, using the admin listener or SIGUSR1.
+	level := new(slog.LevelVar)
+	level.Set(baseLevel)
+	logger, err := logging.New(os.Stdout, *logFormat, level)
+	if err != nil {
+		slog.Error("Invalid -log.format argument.", "error", err)
+		os.Exit(2)
 	}
-	slog.SetDefault(slog.New(slog.NewTextHandler(
-		os.Stdout,
-		&slog.HandlerOptions{
-			AddSource: true,
-			Level:     level,
-		},
-	)))
+	slog.SetDefault(logger)
 
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer cancel()
@@ -75,6 +122,52 @@ func main() {
 		os.Exit(2)
 	}
 
+	if err := event.Validate(); err != nil {
+		slog.ErrorContext(ctx, "Invalid -event argument.", "error", err)
+		os.Exit(2)
+	}
+
+	if *searchCacheSize < 0 {
+		slog.ErrorContext(ctx, "Invalid -search.cache.size argument, it must not be negative.")
+		os.Exit(2)
+	}
+
+	seedSources, err := catalog.ParseSources(*catalogPlaylists)
+	if err != nil {
+		slog.ErrorContext(ctx, "Invalid -catalog.playlists argument.", "error", err)
+		os.Exit(2)
+	}
+
+	poolSources, err := catalog.ParseSources(*catalogRestrict)
+	if err != nil {
+		slog.ErrorContext(ctx, "Invalid -catalog.restrict argument.", "error", err)
+		os.Exit(2)
+	}
+
+	picksSources, err := catalog.ParseSources(*browsePicks)
+	if err != nil {
+		slog.ErrorContext(ctx, "Invalid -browse.picks argument.", "error", err)
+		os.Exit(2)
+	}
+
+	translations, err := i18n.New(*defaultLocale)
+	if err != nil {
+		slog.ErrorContext(ctx, "Invalid -locale.default argument.", "error", err)
+		os.Exit(2)
+	}
+
+	// Local logos are served by ourselves, everything else is referenced as is.
+	logoPath := ""
+	if event.Logo != "" && !strings.HasPrefix(event.Logo, "http://") && !strings.HasPrefix(event.Logo, "https://") {
+		logoPath, event.Logo = event.Logo, "/event/logo"
+	}
+
+	bundle, err := assets.New(assets.Mode(*assetsMode))
+	if err != nil {
+		slog.ErrorContext(ctx, "Could not set up assets.", "error", err)
+		os.Exit(2)
+	}
+
 	otelSink, err := prometheus.New()
 	if err != nil {
 		slog.ErrorContext(ctx, "Failed to setup opentelemetry prometheus collector.", "error", err)
@@ -92,8 +185,32 @@ func main() {
 	)
 	otel.SetMeterProvider(provider)
 
+	// Exiting unsuccessfully lets the service manager restart the app, but only after traces have been flushed below.
+	exitCode := 0
+	defer func() {
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	}()
+
+	shutdownTracing, err := setupTracing(ctx, *tracingExporter, *tracingEndpoint, *tracingPath, *tracingRatio, service)
+	if err != nil {
+		slog.ErrorContext(ctx, "Invalid -tracing arguments.", "error", err)
+		os.Exit(2)
+	}
+	defer func() {
+		flushCtx, flushCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer flushCancel()
+		if err := shutdownTracing(flushCtx); err != nil {
+			slog.Error("Problem flushing traces.", "error", err)
+		}
+	}()
+
 	metricServer := api.NewServer("metrics", *metricsListen)
+	metricServer.Use(logging.RequestIDs, api.Recover(nil), api.SecurityHeaders())
 	metricServer.Handle("/metrics", promhttp.Handler())
+	// All other components depend on the metrics server, so it is stopped last and their shutdowns can still be scraped.
+	metrics := metricServer.Component()
 
 	// Setup our OAuth service, which will restore and persist a token it has obtained. It will obtain those through
 	// the admin api handlers which have access to this service.
@@ -103,10 +220,63 @@ func main() {
 	// that will use a token automatically. The context is used for refreshing the token.
 	spotify := spotifylib.New(oauthService)
 
-	// Setup our realtime service, which gets the now playing song from spotify at an interval and multiplexes the
-	// info to all users.
-	spotifyRealtimeSubscription := realtime.NewService(spotify, *nowPlayingFrequency)
-	spotifyRealtimeSubscription.Start(ctx)
+	// Keep the token fresh in the background. Everything querying spotify in the background waits for a token to be
+	// available, which requires the admin to log in on first start.
+	tokenRefresher := &api.Component{
+		Name:      "token-refresher",
+		Run:       oauthService.KeepFresh,
+		DependsOn: []*api.Component{metrics},
+		Restart:   api.RestartPolicy{Attempts: 5, Backoff: time.Second, MaxBackoff: time.Minute},
+	}
+
+	// Setup our realtime bus, which multiplexes live events to all users, and the realtime service, which gets the now
+	// playing song and queue from spotify at an interval and publishes them on the bus.
+	bus := realtime.NewBus()
+	spotifyRealtimeSubscription := realtime.NewService(bus, spotify, *nowPlayingFrequency, *nowPlayingFrequencyMin)
+	realtimePoller := api.NewComponent("realtime-poller", spotifyRealtimeSubscription.Run, tokenRefresher)
+	realtimePoller.Restart = api.RestartPolicy{Attempts: 5, Backoff: time.Second, MaxBackoff: time.Minute}
+
+	// Setup the search cache, which spares spotify from answering the same searches over and over again.
+	searchCache := search.NewCache(spotify, *searchCacheSize, *searchCacheTTL)
+
+	// Setup the request history, which remembers which guest requested what.
+	history := requests.NewHistory(*historyPersistPath)
+
+	// Setup the votes, which guests cast on songs over their sockets.
+	votes := requests.NewVotes()
+
+	// Setup the local catalog, which is searched when spotify is unreachable. It is seeded with everything requested so
+	// far and prefetched playlists, and grows with each search.
+	localCatalog := catalog.New(*catalogPersistPath)
+	for _, request := range history.Since(time.Time{}) {
+		localCatalog.Add(request.Song)
+	}
+	seedSources = append(seedSources, catalog.Source{Type: spotifylib.SearchPlaylist, ID: *playlistID})
+	prefetchers := []*api.Component{
+		api.NewComponent("catalog-prefetcher", func(ctx context.Context) error {
+			localCatalog.Prefetch(ctx, spotify, *searchMarket, seedSources, *catalogRefresh)
+			return nil
+		}, tokenRefresher),
+	}
+
+	// Setup the pool guests are restricted to, if any. It is fetched from its sources and searched locally.
+	var pool *catalog.Catalog
+	if len(poolSources) > 0 {
+		pool = catalog.NewPool(*catalogRestrictPath)
+		prefetchers = append(prefetchers, api.NewComponent("pool-prefetcher", func(ctx context.Context) error {
+			pool.Prefetch(ctx, spotify, *searchMarket, poolSources, *catalogRefresh)
+			return nil
+		}, tokenRefresher))
+	}
+
+	// Setup the songs picked by the hosts, which are suggested before searching.
+	picks := catalog.NewPool("")
+	if len(picksSources) > 0 {
+		prefetchers = append(prefetchers, api.NewComponent("picks-prefetcher", func(ctx context.Context) error {
+			picks.Prefetch(ctx, spotify, *searchMarket, picksSources, *catalogRefresh)
+			return nil
+		}, tokenRefresher))
+	}
 
 	// Make an initial request to spotify to log some info about our credentials.
 	user, err := spotify.User(ctx)
@@ -118,38 +288,140 @@ func main() {
 
 	// Expose regular handlers on one listener.
 	userServer := api.NewServer("user", *serverListen)
-	userServer.Handle("/", templ.Handler(ui.Index()))
+	userServer.Use(
+		logging.RequestIDs,
+		api.SecurityHeaders(bundle.Origins()...), // Allows scripts referenced on CDNs.
+		api.Compress,
+		translations.Middleware,
+		api.Recover(ui.ErrorMessage()), // Renders translated errors, thus inside of translations.
+	)
+	userServer.Handle("/", handlers.IndexHandler(
+		bundle, // Used to reference scripts and styles.
+		event,  // Used for branding.
+		pool,   // Used to leave out filters not applying to the pool, if set.
+	))
+	userServer.Handle("GET /locale", handlers.LocaleHandler(
+		translations, // Used to validate the chosen language.
+	))
+	if logoPath != "" {
+		userServer.Handle("GET /event/logo", handlers.FileHandler(
+			logoPath, // The local logo file.
+		))
+	}
+	userServer.Handle("GET "+assets.PathPrefix, handlers.StaticHandler(
+		bundle, // Used to look up embedded assets.
+	))
 	userServer.Handle("/now-playing", handlers.NowPlayingHandler(
 		spotify, // Used for the initial page render only.
 	))
 	userServer.Handle("/now-playing-live", handlers.NowPlayingLiveHandler(
-		spotifyRealtimeSubscription, // Used to subscribe to continuous live updates.
-		*serverName,                 // Used for CORS headers.
+		bus,         // Used to subscribe to continuous live updates.
+		*serverName, // Used for CORS headers.
+	))
+	userServer.Handle("GET /now-playing-socket", handlers.NowPlayingSocketHandler(
+		bus,         // Used to subscribe to continuous live updates.
+		history,     // Used to check what can be voted on.
+		votes,       // Used to tally votes cast by guests.
+		*serverName, // Used to check the origin of connections.
 	))
 	userServer.Handle("POST /search", handlers.SearchHandler(
-		spotify,       // Used to facilitate search.
+		spotify,       // Used to resolve pasted links.
+		searchCache,   // Used to facilitate search.
+		localCatalog,  // Used when spotify is unreachable.
+		pool,          // Used to restrict what can be found, if set.
+		history,       // Used to suggest what others requested.
+		picks,         // Used to suggest what the hosts picked.
 		*searchMarket, // Limit to the given market area.
 		*searchLimit,  // Limit to a number of results.
 	))
+	userServer.Handle("GET /artist/{id}", handlers.ArtistHandler(
+		spotify,       // Used to look up the artist's songs.
+		*searchMarket, // Limit to the given market area.
+	))
+	userServer.Handle("GET /album/{id}", handlers.AlbumHandler(
+		spotify,       // Used to look up the album's songs.
+		*searchMarket, // Limit to the given market area.
+	))
+	userServer.Handle("GET /playlist/{id}", handlers.PlaylistHandler(
+		spotify,       // Used to look up the playlist's songs.
+		*searchMarket, // Limit to the given market area.
+	))
 	userServer.Handle("POST /add", handlers.AddHandler(
-		spotify,     // Used to facilitate adding to playlists.
-		*playlistID, // What playlist to add to.
+		spotify,       // Used to facilitate adding to playlists.
+		*playlistID,   // What playlist to add to.
+		*searchMarket, // Used to look up details of added songs.
+		history,       // Used to record who requested what.
+		pool,          // Used to restrict what can be picked, if set.
+		bus,           // Used to announce requests.
+	))
+	userServer.Handle("/screen", handlers.ScreenHandler(
+		bundle,      // Used to reference scripts and styles.
+		event,       // Used for branding.
+		*serverName, // Where the QR code points guests to.
+	))
+	userServer.Handle("/screen-live", handlers.ScreenLiveHandler(
+		bus,         // Used to subscribe to continuous live updates.
+		history,     // Used to show who requested upcoming songs.
+		*serverName, // Used for CORS headers.
+	))
+	userServer.Handle("GET /screen-socket", handlers.ScreenSocketHandler(
+		bus,         // Used to subscribe to continuous live updates.
+		history,     // Used to show who requested upcoming songs.
+		votes,       // Used to tally votes cast by guests.
+		*serverName, // Used to check the origin of connections.
 	))
 
 	// Expose admin handlers on different listeners, admin listener for initiation and public for callback.
 	adminServer := api.NewServer("admin", *authListen)
+	adminServer.Use(logging.RequestIDs, api.Recover(nil), api.SecurityHeaders())
 	adminServer.Handle("/", handlers.OAuthLoginHandler(
 		oauthService,
 	))
+	adminServer.Handle("/log/level", handlers.LogLevelHandler(
+		level, // The level to show and change.
+	))
 	userServer.Handle("/spotify/callback", handlers.OAuthCallbackHandler(
 		oauthService,
 		spotify,
 	))
 
-	// Orchestrate all servers to run and shutdown later.
-	eg := api.Orchestrate(ctx, 5*time.Second, userServer, adminServer, metricServer)
-	if err = eg.Wait(); err != nil {
-		slog.Error("Unclean termination of at least one server.", "error", err)
+	// Check regularly whether the app is functional, so probes and the status page can answer right away.
+	users := health.NewUserLookup(spotify)
+	checker := health.NewChecker(*healthInterval,
+		health.TokenCheck(oauthService),
+		health.SpotifyCheck(users),
+		health.PlaylistCheck(spotify, users, *playlistID),
+		health.CircuitCheck(searchCache),
+		health.PollerCheck(spotifyRealtimeSubscription),
+	)
+
+	// Orchestrate all servers and background components to run and shutdown later. The servers do not depend on the
+	// token, as the admin needs them to log in in the first place.
+	orchestrator := api.NewOrchestrator(5*time.Second, append([]*api.Component{
+		tokenRefresher,
+		realtimePoller,
+		{Name: "health-checker", Run: checker.Run, DependsOn: []*api.Component{metrics}},
+		api.NewComponent("log-level-toggle", func(ctx context.Context) error {
+			return logging.ToggleDebug(ctx, level, baseLevel, syscall.SIGUSR1)
+		}, metrics),
+		userServer.Component(metrics),
+		adminServer.Component(metrics),
+		metrics,
+	}, prefetchers...)...)
+
+	// Expose the health of the app next to its metrics, for probes and the hosts.
+	metricServer.Handle("GET /healthz", handlers.HealthzHandler())
+	metricServer.Handle("GET /readyz", handlers.ReadyzHandler(
+		orchestrator, // Used to check all components are running.
+		checker,      // Used to check the app is functional.
+	))
+	metricServer.Handle("GET /debug/status", handlers.StatusHandler(
+		orchestrator, // Used to show the state of all components.
+		checker,      // Used to show the results of all checks.
+	))
+	if err = orchestrator.Run(ctx); err != nil {
+		slog.Error("Unclean termination of at least one component.", "error", err)
+		exitCode = 1
 	}
 
 	slog.Info("Exiting, kthxbye.")
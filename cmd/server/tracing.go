@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	"go.opentelemetry.io/otel/sdk/trace"
+)
+
+// setupTracing installs a global tracer provider sending spans to the given exporter, which is one of "none", "otlp",
+// "stderr" or "file". The returned function flushes remaining spans and should be called before exiting.
+func setupTracing(ctx context.Context, exporter string, endpoint string, path string, ratio float64, service *resource.Resource) (func(context.Context) error, error) {
+	// Propagate trace context regardless of the exporter, so traces of callers are continued.
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	var spanExporter trace.SpanExporter
+	closeFile := func() error { return nil }
+	switch exporter {
+	case "none", "":
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+		var err error
+		spanExporter, err = otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+		if err != nil {
+			return nil, fmt.Errorf("setting up otlp exporter: %w", err)
+		}
+	case "stderr":
+		// Logs are written to stdout, spans are kept apart so either can still be parsed line by line.
+		var err error
+		spanExporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stderr))
+		if err != nil {
+			return nil, fmt.Errorf("setting up stderr exporter: %w", err)
+		}
+	case "file":
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("opening trace file: %w", err)
+		}
+		closeFile = f.Close
+		spanExporter, err = stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			return nil, errors.Join(fmt.Errorf("setting up file exporter: %w", err), f.Close())
+		}
+	default:
+		return nil, fmt.Errorf("unknown exporter %q", exporter)
+	}
+
+	provider := trace.NewTracerProvider(
+		trace.WithBatcher(spanExporter),
+		trace.WithResource(service),
+		trace.WithSampler(trace.ParentBased(trace.TraceIDRatioBased(ratio))),
+	)
+	otel.SetTracerProvider(provider)
+	return func(ctx context.Context) error {
+		return errors.Join(provider.Shutdown(ctx), closeFile())
+	}, nil
+}
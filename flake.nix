@@ -189,6 +189,8 @@
                 "-auth.token.path=/var/lib/wunschkonzert/token.json"
                 "-auth.listen=${cfg.auth.listen}"
                 "-playlist.id=${cfg.playlist}"
+                "-history.path=/var/lib/wunschkonzert/history.jsonl"
+                "-catalog.path=/var/lib/wunschkonzert/catalog.jsonl"
                 "-metrics.listen=${cfg.metrics.listen}"
               ]
               ++ (lib.optional cfg.verbose "-verbose")
@@ -1,47 +1,267 @@
 package handlers
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/a-h/templ"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/debugloop/wunschkonzert/pkg/assets"
+	"github.com/debugloop/wunschkonzert/pkg/catalog"
 	"github.com/debugloop/wunschkonzert/pkg/realtime"
+	"github.com/debugloop/wunschkonzert/pkg/requests"
+	"github.com/debugloop/wunschkonzert/pkg/search"
 	spotifylib "github.com/debugloop/wunschkonzert/pkg/spotify"
 	"github.com/debugloop/wunschkonzert/pkg/ui"
 )
 
-// SearchHandler returns the handler responsible for searching. It connects directly to the spotify search.
-func SearchHandler(spotify *spotifylib.Client, market string, limit uint) http.Handler {
+const (
+	// guestCookie remembers the name a guest has entered with their last request.
+	guestCookie = "guest"
+	// trendingWindow is how far back requests are considered trending.
+	trendingWindow = time.Hour
+	// browseLength is the number of trending songs and picks suggested while the search box is empty.
+	browseLength = 10
+)
+
+// IndexHandler returns the handler rendering the main page. It identifies the guest's browser for voting right away. If
+// a pool is given, genre filters are not offered, as songs in the pool have no genre.
+func IndexHandler(bundle *assets.Bundle, event *ui.Event, pool *catalog.Catalog) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			voterID(w, req)
+			guest := ""
+			if cookie, err := req.Cookie(guestCookie); err == nil {
+				guest, _ = url.QueryUnescape(cookie.Value)
+			}
+			templ.Handler(ui.Index(bundle, event, guest, pool == nil)).ServeHTTP(w, req)
+		},
+	)
+}
+
+// SearchHandler returns the handler responsible for searching. It connects directly to the spotify search. The first
+// page includes artists, albums and playlists, further pages only contain songs. Pasted links are resolved directly.
+// Songs found are added to the local catalog, which is searched instead if spotify is unreachable. If a pool is given,
+// guests can only search within it. While the search box is empty, songs requested recently and picked by the hosts are
+// suggested instead.
+func SearchHandler(spotify *spotifylib.Client, cache *search.Cache, localCatalog *catalog.Catalog, pool *catalog.Catalog, history *requests.History, picks *catalog.Catalog, market string, limit uint) http.Handler {
+	meter := otel.GetMeterProvider().Meter("github.com/debugloop/wunschkonzert/pkg/api/handlers")
+	searches, err := meter.Int64Counter(
+		"search.count",
+		metric.WithDescription("The number of searches, by where results came from."),
+	)
+	if err != nil {
+		slog.Error("Problem setting up otel instrumentation.", "error", err)
+	}
+	results, err := meter.Int64Histogram(
+		"search.result.count",
+		metric.WithDescription("The number of songs found per search, by where they came from."),
+		metric.WithExplicitBucketBoundaries(0, 1, 2, 5, 10, 15, 20, 50, 100),
+	)
+	if err != nil {
+		slog.Error("Problem setting up otel instrumentation.", "error", err)
+	}
+	empty, err := meter.Int64Counter(
+		"search.empty.count",
+		metric.WithDescription("The number of searches without any songs found, by where they were searched."),
+	)
+	if err != nil {
+		slog.Error("Problem setting up otel instrumentation.", "error", err)
+	}
+	record := func(ctx context.Context, source string, songs int) {
+		attrs := metric.WithAttributes(attribute.String("source", source))
+		searches.Add(ctx, 1, attrs)
+		results.Record(ctx, int64(songs), attrs)
+		if songs == 0 {
+			empty.Add(ctx, 1, attrs)
+		}
+	}
+
 	return http.HandlerFunc(
 		func(w http.ResponseWriter, req *http.Request) {
 			err := req.ParseForm()
 			if err != nil {
-				slog.Error("Could not parse form.", "error", err)
+				slog.ErrorContext(req.Context(), "Could not parse form.", "error", err)
+				return
+			}
+
+			query := searchQuery(req)
+			if query.IsEmpty() {
+				trending := history.Trending(time.Now().Add(-trendingWindow), browseLength)
+				suggested := inPool(pool, picks.Songs(uint(picks.Len())), browseLength)
+				err = render(req.Context(), w, "Browse", ui.Browse(trending, suggested, pool == nil))
+				if err != nil {
+					slog.ErrorContext(req.Context(), "Unable to render or send response.", "error", err)
+				}
+				return
+			}
+
+			if pool != nil {
+				slog.InfoContext(req.Context(), "Someone searched the pool.", "query", query.String())
+
+				resp := &spotifylib.SearchResult{}
+				resp.Tracks.Songs = pool.Search(query, limit)
+				record(req.Context(), "pool", len(resp.Tracks.Songs))
+				err = render(req.Context(), w, "SearchResult", ui.SearchResult(resp))
+				if err != nil {
+					slog.ErrorContext(req.Context(), "Unable to render or send response.", "error", err)
+				}
 				return
 			}
 
-			query := req.FormValue("search")
-			if query == "" {
+			if kind, id, ok := spotifylib.ParseLink(query.Text); ok {
+				slog.InfoContext(req.Context(), "Someone pasted a link.", "type", kind, "id", id)
+
+				resp, err := resolveLink(req.Context(), spotify, kind, id, market)
+				if err != nil {
+					slog.ErrorContext(req.Context(), "Problem resolving link with spotify.", "error", err)
+					return
+				}
+				record(req.Context(), "link", len(resp.Tracks.Songs))
+
+				err = render(req.Context(), w, "SearchResult", ui.SearchResult(resp))
+				if err != nil {
+					slog.ErrorContext(req.Context(), "Unable to render or send response.", "error", err)
+				}
 				return
 			}
 
-			slog.Info("Someone searched something.", "query", query)
+			// A malformed offset is treated like a fresh search.
+			offset, _ := strconv.ParseUint(req.FormValue("offset"), 10, 32)
+			if offset > 0 {
+				resp, err := cache.Search(req.Context(), query, market, limit, uint(offset), spotifylib.SearchTrack)
+				if err != nil {
+					slog.ErrorContext(req.Context(), "Problem retrieving more search results from spotify.", "error", err)
+					return
+				}
+				record(req.Context(), "more", len(resp.Tracks.Songs))
+
+				err = render(req.Context(), w, "SearchMore", ui.SearchMore(resp))
+				if err != nil {
+					slog.ErrorContext(req.Context(), "Unable to render or send response.", "error", err)
+				}
+				return
+			}
 
-			resp, err := spotify.Search(req.Context(), query, market, limit)
+			slog.InfoContext(req.Context(), "Someone searched something.", "query", query.String())
+
+			resp, err := cache.Search(req.Context(), query, market, limit, 0,
+				spotifylib.SearchTrack, spotifylib.SearchArtist, spotifylib.SearchAlbum, spotifylib.SearchPlaylist)
 			if err != nil {
-				slog.Error("Problem retrieving search results from spotify.", "error", err)
+				slog.WarnContext(req.Context(), "Problem retrieving search results from spotify, falling back to local catalog.", "error", err)
+
+				resp = &spotifylib.SearchResult{}
+				resp.Tracks.Songs = localCatalog.Search(query, limit)
+				record(req.Context(), "local", len(resp.Tracks.Songs))
+				err = render(req.Context(), w, "OfflineSearchResult", ui.OfflineSearchResult(resp))
+				if err != nil {
+					slog.ErrorContext(req.Context(), "Unable to render or send response.", "error", err)
+				}
 				return
 			}
 
-			err = ui.SearchResult(resp).Render(req.Context(), w)
+			localCatalog.Add(resp.Tracks.Songs...)
+			if resp.Tracks.Next == "" {
+				resp.Tracks.Songs = mergeSongs(resp.Tracks.Songs, localCatalog.Search(query, limit), limit)
+			}
+			record(req.Context(), "spotify", len(resp.Tracks.Songs))
+
+			err = render(req.Context(), w, "SearchResult", ui.SearchResult(resp))
 			if err != nil {
-				slog.Error("Unable to render or send response.", "error", err)
+				slog.ErrorContext(req.Context(), "Unable to render or send response.", "error", err)
 				return
 			}
 		},
 	)
 }
 
+// mergeSongs fills up live results with local ones, skipping songs already included.
+func mergeSongs(live []spotifylib.Song, local []spotifylib.Song, limit uint) []spotifylib.Song {
+	seen := make(map[string]struct{}, len(live))
+	for _, song := range live {
+		seen[song.URI] = struct{}{}
+	}
+	for _, song := range local {
+		if uint(len(live)) >= limit {
+			break
+		}
+		if _, ok := seen[song.URI]; !ok {
+			live = append(live, song)
+		}
+	}
+	return live
+}
+
+// searchQuery builds the query from the search box and the filters chosen alongside it. Malformed decades are ignored.
+func searchQuery(req *http.Request) spotifylib.Query {
+	query := spotifylib.Query{
+		Text:  req.FormValue("search"),
+		Genre: req.FormValue("genre"),
+	}
+	if decade, err := strconv.ParseUint(req.FormValue("decade"), 10, 16); err == nil && decade > 0 {
+		query = query.Decade(uint(decade))
+	}
+	return query
+}
+
+// inPool returns up to limit of the given songs which guests are allowed to pick, which are all of them without a pool.
+func inPool(pool *catalog.Catalog, songs []spotifylib.Song, limit int) []spotifylib.Song {
+	allowed := make([]spotifylib.Song, 0, min(limit, len(songs)))
+	for _, song := range songs {
+		if len(allowed) >= limit {
+			break
+		}
+		if pool == nil || pool.Contains(song.URI) {
+			allowed = append(allowed, song)
+		}
+	}
+	return allowed
+}
+
+// resolveLink looks up the songs of a linked item and wraps them in a SearchResult, so they can be rendered just like
+// the results of a text search.
+func resolveLink(ctx context.Context, spotify *spotifylib.Client, kind spotifylib.SearchType, id string, market string) (*spotifylib.SearchResult, error) {
+	result := &spotifylib.SearchResult{}
+	switch kind {
+	case spotifylib.SearchTrack:
+		song, err := spotify.Track(ctx, id, market)
+		if err != nil {
+			return nil, err
+		}
+		if song != nil {
+			result.Tracks.Songs = []spotifylib.Song{*song}
+		}
+	case spotifylib.SearchAlbum:
+		album, err := spotify.Album(ctx, id, market)
+		if err != nil || album == nil {
+			return result, err
+		}
+		result.Tracks.Songs = album.Songs()
+	case spotifylib.SearchPlaylist:
+		playlist, err := spotify.Playlist(ctx, id, market)
+		if err != nil || playlist == nil {
+			return result, err
+		}
+		result.Tracks.Songs = playlist.Songs()
+	case spotifylib.SearchArtist:
+		topTracks, err := spotify.ArtistTopTracks(ctx, id, market)
+		if err != nil || topTracks == nil {
+			return result, err
+		}
+		result.Tracks.Songs = topTracks.Songs
+	}
+	return result, nil
+}
+
 // NowPlaying is the handler returning the NowPlayingSection. It includes an initial render of the inner NowPlaying
 // widget, which will be updated using SSE.
 func NowPlayingHandler(spotify *spotifylib.Client) http.Handler {
@@ -52,7 +272,7 @@ func NowPlayingHandler(spotify *spotifylib.Client) http.Handler {
 				slog.WarnContext(req.Context(), "Problem retrieving now playing data from spotify, rendering anyways.", "error", err)
 			}
 
-			err = ui.NowPlayingSection(resp).Render(req.Context(), w)
+			err = render(req.Context(), w, "NowPlayingSection", ui.NowPlayingSection(resp))
 			if err != nil {
 				slog.ErrorContext(req.Context(), "Unable to render or send response.", "error", err)
 				return
@@ -61,58 +281,28 @@ func NowPlayingHandler(spotify *spotifylib.Client) http.Handler {
 	)
 }
 
-// NowPlayingLive is the SSE handler which continuously updates the widget inside NowPlayingSection.
-func NowPlayingLiveHandler(realtimeService *realtime.Service, serverName string) http.Handler {
-	return http.HandlerFunc(
-		func(w http.ResponseWriter, req *http.Request) {
-			w.Header().Set("Access-Control-Allow-Origin", serverName)
-			w.Header().Set("Access-Control-Expose-Headers", "Content-Type")
-
-			w.Header().Set("Content-Type", "text/event-stream")
-			w.Header().Set("Cache-Control", "no-cache")
-			w.Header().Set("Connection", "keep-alive")
-
-			updates := make(chan *spotifylib.NowPlaying)
-			realtimeService.Subscribe(req.Context(), updates)
-			defer realtimeService.Unsubscribe(req.Context(), updates)
-			for {
-				select {
-				case <-req.Context().Done():
-					return
-				case np, ok := <-updates:
-					if !ok {
-						return
-					}
-					_, err := fmt.Fprintf(w, "data: ")
-					if err != nil {
-						slog.ErrorContext(req.Context(), "Unable to start SSE frame.", "error", err)
-						return
-					}
-					err = ui.NowPlaying(np).Render(req.Context(), w)
-					if err != nil {
-						slog.ErrorContext(req.Context(), "Unable to render or send response.", "error", err)
-						return
-					}
-					_, err = fmt.Fprintf(w, "\n\n")
-					if err != nil {
-						slog.ErrorContext(req.Context(), "Unable to complete SSE frame.", "error", err)
-						return
-					}
-					w.(http.Flusher).Flush()
-				}
-			}
-		},
+// AddHandler returns the handler accepting additions to a given playlist. It is passed directly to spotify and will
+// return a disabled button if successful. Successful requests are recorded in the history, along with the guest's name
+// if they have given one, and announced on the bus. Songs requested before are not added again. If a pool is given,
+// only songs within it are accepted.
+func AddHandler(spotify *spotifylib.Client, playlistID string, market string, history *requests.History, pool *catalog.Catalog, bus *realtime.Bus) http.Handler {
+	meter := otel.GetMeterProvider().Meter("github.com/debugloop/wunschkonzert/pkg/api/handlers")
+	adds, err := meter.Int64Counter(
+		"request.add.count",
+		metric.WithDescription("The number of songs picked by guests, by outcome. Songs requested before are not added and count as duplicate."),
 	)
-}
+	if err != nil {
+		slog.Error("Problem setting up otel instrumentation.", "error", err)
+	}
+	record := func(ctx context.Context, outcome string) {
+		adds.Add(ctx, 1, metric.WithAttributes(attribute.String("outcome", outcome)))
+	}
 
-// AddHandler returns the handler accepting additions to a given playlist. It is passed directly to spotify and will
-// return a disabled button if successful.
-func AddHandler(spotify *spotifylib.Client, playlistID string) http.Handler {
 	return http.HandlerFunc(
 		func(w http.ResponseWriter, req *http.Request) {
 			err := req.ParseForm()
 			if err != nil {
-				slog.Error("Could not parse form.", "error", err)
+				slog.ErrorContext(req.Context(), "Could not parse form.", "error", err)
 				return
 			}
 
@@ -121,17 +311,77 @@ func AddHandler(spotify *spotifylib.Client, playlistID string) http.Handler {
 				return
 			}
 
-			slog.Info("Someone has picked a song.", "song", song)
+			guest := strings.TrimSpace(req.FormValue("guest"))
+			if runes := []rune(guest); len(runes) > 40 {
+				guest = string(runes[:40])
+			}
+
+			if pool != nil && !pool.Contains(song) {
+				slog.WarnContext(req.Context(), "Someone has picked a song outside of the pool.", "song", song)
+				record(req.Context(), "blocked")
+				err = render(req.Context(), w, "BlockedButton", ui.BlockedButton())
+				if err != nil {
+					slog.ErrorContext(req.Context(), "Unable to render or send response.", "error", err)
+				}
+				return
+			}
+
+			if history.Requested(song) {
+				slog.InfoContext(req.Context(), "Someone has picked a song requested before.", "song", song)
+				record(req.Context(), "duplicate")
+				err = render(req.Context(), w, "DuplicateButton", ui.DuplicateButton())
+				if err != nil {
+					slog.ErrorContext(req.Context(), "Unable to render or send response.", "error", err)
+				}
+				return
+			}
+
+			slog.InfoContext(req.Context(), "Someone has picked a song.", "song", song)
 
 			err = spotify.AddToPlaylist(req.Context(), playlistID, song)
-			if err != nil {
-				slog.Error("Problem adding song to spotify playlist.", "error", err)
+			var statusErr *spotifylib.StatusError
+			switch {
+			case errors.As(err, &statusErr) && statusErr.Code == http.StatusTooManyRequests:
+				slog.WarnContext(req.Context(), "Adding song to spotify playlist was rate limited.", "error", err)
+				record(req.Context(), "rate-limited")
+				return
+			case err != nil:
+				slog.ErrorContext(req.Context(), "Problem adding song to spotify playlist.", "error", err)
+				record(req.Context(), "failed")
 				return
+			default:
+				record(req.Context(), "success")
 			}
 
-			err = ui.DisabledButton().Render(req.Context(), w)
+			// The history should contain the song's details, but the addition itself has been successful regardless.
+			details := &spotifylib.Song{URI: song}
+			if id, ok := strings.CutPrefix(song, "spotify:track:"); ok {
+				if track, err := spotify.Track(req.Context(), id, market); err == nil && track != nil {
+					details = track
+				} else {
+					slog.WarnContext(req.Context(), "Could not retrieve details of picked song.", "song", song, "error", err)
+				}
+			}
+			request := requests.Request{
+				Song:  *details,
+				Guest: guest,
+				At:    time.Now(),
+			}
+			history.Add(request)
+			bus.Publish(req.Context(), realtime.RequestAdded{Request: request})
+
+			http.SetCookie(w, &http.Cookie{
+				Name:     guestCookie,
+				Value:    url.QueryEscape(guest),
+				Path:     "/",
+				Expires:  time.Now().AddDate(0, 0, 7),
+				SameSite: http.SameSiteLaxMode,
+				HttpOnly: true,
+			})
+
+			err = render(req.Context(), w, "DisabledButton", ui.DisabledButton())
 			if err != nil {
-				slog.Error("Unable to render or send response.", "error", err)
+				slog.ErrorContext(req.Context(), "Unable to render or send response.", "error", err)
 				return
 			}
 		},
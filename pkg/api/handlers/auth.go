@@ -2,7 +2,7 @@ package handlers
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"math/rand"
 	"net/http"
 
@@ -37,7 +37,7 @@ func OAuthCallbackHandler(oauthService *auth.OAuthService, spotify *spotify.Clie
 			token, err := oauthService.Config().Exchange(req.Context(), code)
 			if err != nil {
 				http.Error(w, "Failed to exchange token", http.StatusUnauthorized)
-				log.Println("Token exchange error:", err)
+				slog.ErrorContext(req.Context(), "Token exchange failed.", "error", err)
 				return
 			}
 
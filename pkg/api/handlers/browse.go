@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+
+	spotifylib "github.com/debugloop/wunschkonzert/pkg/spotify"
+	"github.com/debugloop/wunschkonzert/pkg/ui"
+)
+
+// ArtistHandler returns the handler listing the most popular songs of an artist found by searching.
+func ArtistHandler(spotify *spotifylib.Client, market string) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			id := req.PathValue("id")
+			resp, err := spotify.ArtistTopTracks(req.Context(), id, market)
+			if err != nil || resp == nil {
+				slog.ErrorContext(req.Context(), "Problem retrieving top tracks from spotify.", "artist", id, "error", err)
+				return
+			}
+
+			title := ""
+			for _, song := range resp.Songs {
+				for _, artist := range song.Artists {
+					if artist.ID == id {
+						title = artist.Name
+					}
+				}
+			}
+
+			err = render(req.Context(), w, "TrackList", ui.TrackList(title, resp.Songs))
+			if err != nil {
+				slog.ErrorContext(req.Context(), "Unable to render or send response.", "error", err)
+				return
+			}
+		},
+	)
+}
+
+// AlbumHandler returns the handler listing the songs of an album found by searching.
+func AlbumHandler(spotify *spotifylib.Client, market string) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			id := req.PathValue("id")
+			resp, err := spotify.Album(req.Context(), id, market)
+			if err != nil || resp == nil {
+				slog.ErrorContext(req.Context(), "Problem retrieving album from spotify.", "album", id, "error", err)
+				return
+			}
+
+			err = render(req.Context(), w, "TrackList", ui.TrackList(resp.Name, resp.Songs()))
+			if err != nil {
+				slog.ErrorContext(req.Context(), "Unable to render or send response.", "error", err)
+				return
+			}
+		},
+	)
+}
+
+// PlaylistHandler returns the handler listing the songs of a playlist found by searching.
+func PlaylistHandler(spotify *spotifylib.Client, market string) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			id := req.PathValue("id")
+			resp, err := spotify.Playlist(req.Context(), id, market)
+			if err != nil || resp == nil {
+				slog.ErrorContext(req.Context(), "Problem retrieving playlist from spotify.", "playlist", id, "error", err)
+				return
+			}
+
+			err = render(req.Context(), w, "TrackList", ui.TrackList(resp.Name, resp.Songs()))
+			if err != nil {
+				slog.ErrorContext(req.Context(), "Unable to render or send response.", "error", err)
+				return
+			}
+		},
+	)
+}
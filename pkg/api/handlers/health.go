@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/a-h/templ"
+
+	"github.com/debugloop/wunschkonzert/pkg/api"
+	"github.com/debugloop/wunschkonzert/pkg/health"
+	"github.com/debugloop/wunschkonzert/pkg/ui"
+)
+
+// HealthzHandler returns the handler for liveness probes. It only tells that the process is serving requests.
+func HealthzHandler() http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			_, _ = fmt.Fprintln(w, "ok")
+		},
+	)
+}
+
+// ReadyzHandler returns the handler for readiness probes. The app is ready once all components are running and all
+// checks have passed. Otherwise, it responds with 503 and lists what is failing.
+func ReadyzHandler(orchestrator *api.Orchestrator, checker *health.Checker) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			failures := []string{}
+			for _, component := range orchestrator.Components() {
+				if !component.Ready() {
+					failures = append(failures, fmt.Sprintf("component %s: not ready", component.Name))
+				}
+			}
+			results := checker.Results()
+			if results == nil {
+				failures = append(failures, "checks: not run yet")
+			}
+			for _, result := range results {
+				if result.Err != nil {
+					failures = append(failures, fmt.Sprintf("check %s: %s", result.Name, result.Err))
+				}
+			}
+
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Header().Set("Cache-Control", "no-cache")
+			if len(failures) > 0 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				for _, failure := range failures {
+					_, _ = fmt.Fprintln(w, failure)
+				}
+				return
+			}
+			_, _ = fmt.Fprintln(w, "ready")
+		},
+	)
+}
+
+// StatusHandler returns the handler rendering a page which summarizes the state of all components and checks.
+func StatusHandler(orchestrator *api.Orchestrator, checker *health.Checker) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			components := []ui.ComponentStatus{}
+			for _, component := range orchestrator.Components() {
+				components = append(components, ui.ComponentStatus{Name: component.Name, Ready: component.Ready()})
+			}
+			ready := orchestrator.Ready() && checker.Ready()
+			templ.Handler(ui.Status(ready, components, checker.Results())).ServeHTTP(w, req)
+		},
+	)
+}
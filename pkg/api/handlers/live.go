@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/a-h/templ"
+
+	"github.com/debugloop/wunschkonzert/pkg/api"
+	"github.com/debugloop/wunschkonzert/pkg/realtime"
+	"github.com/debugloop/wunschkonzert/pkg/requests"
+	"github.com/debugloop/wunschkonzert/pkg/ui"
+)
+
+const (
+	// sseHeartbeat is how often a comment is sent on idle streams, so proxies do not consider them dead.
+	sseHeartbeat = 15 * time.Second
+	// sseRetry is how long clients wait before reconnecting a broken stream.
+	sseRetry = 3 * time.Second
+	// drainRetry is how long clients are asked to wait before reconnecting when the server shuts down, giving its
+	// replacement time to start.
+	drainRetry = 10 * time.Second
+	// reconnectEvent is the last event sent to clients when the server shuts down.
+	reconnectEvent = "reconnect"
+)
+
+// NowPlayingLiveHandler is the SSE handler which updates the widget inside NowPlayingSection whenever what is playing
+// changes.
+func NowPlayingLiveHandler(bus *realtime.Bus, serverName string) http.Handler {
+	return liveHandler(bus, serverName, nowPlayingRenderer(), realtime.EventNowPlaying)
+}
+
+// ScreenLiveHandler is the SSE handler updating the big screen. The current song, the queue and the latest request are
+// sent as separate events, so each part of the screen updates independently.
+func ScreenLiveHandler(bus *realtime.Bus, history *requests.History, serverName string) http.Handler {
+	return liveHandler(bus, serverName, screenRenderer(history),
+		realtime.EventNowPlaying, realtime.EventQueueChanged, realtime.EventRequestAdded)
+}
+
+// nowPlayingRenderer renders events for the widget inside NowPlayingSection.
+func nowPlayingRenderer() func(realtime.Event) templ.Component {
+	return func(event realtime.Event) templ.Component {
+		switch event := event.(type) {
+		case realtime.NowPlaying:
+			return ui.NowPlaying(event.NowPlaying)
+		}
+		return nil
+	}
+}
+
+// screenRenderer renders events for the parts of the big screen.
+func screenRenderer(history *requests.History) func(realtime.Event) templ.Component {
+	return func(event realtime.Event) templ.Component {
+		switch event := event.(type) {
+		case realtime.NowPlaying:
+			return ui.ScreenNowPlaying(event.NowPlaying)
+		case realtime.QueueChanged:
+			return ui.ScreenQueue(upcomingSongs(event.Queue, history))
+		case realtime.RequestAdded:
+			return ui.ScreenRequest(event.Request)
+		}
+		return nil
+	}
+}
+
+// liveHandler subscribes to the named events and sends them as named SSE events, rendered by the given function. Events
+// rendered as nil are skipped. The latest state is sent right away, unless the client has received it before it had to
+// reconnect. Heartbeats keep proxies from closing the stream while nothing happens. When the server shuts down, clients
+// are told to reconnect later.
+func liveHandler(bus *realtime.Bus, serverName string, render func(realtime.Event) templ.Component, names ...string) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("Access-Control-Allow-Origin", serverName)
+			w.Header().Set("Access-Control-Expose-Headers", "Content-Type")
+
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("Connection", "keep-alive")
+
+			if _, err := fmt.Fprintf(w, "retry: %d\n\n", sseRetry.Milliseconds()); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+
+			heartbeat := time.NewTicker(sseHeartbeat)
+			defer heartbeat.Stop()
+
+			sub := bus.Subscribe(req.Context(), req.Header.Get("Last-Event-ID"), names...)
+			defer bus.Unsubscribe(req.Context(), sub)
+			for {
+				select {
+				case <-req.Context().Done():
+					return
+				case <-sub.Done():
+					return
+				case <-api.Draining(req.Context()):
+					_, _ = fmt.Fprintf(w, "retry: %d\nevent: %s\ndata: \n\n", drainRetry.Milliseconds(), reconnectEvent)
+					w.(http.Flusher).Flush()
+					return
+				case <-heartbeat.C:
+					if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+						return
+					}
+					w.(http.Flusher).Flush()
+				case <-sub.Ready():
+					for _, message := range sub.Receive() {
+						component := render(message.Event)
+						if component == nil {
+							continue
+						}
+						if err := sendEvent(req.Context(), w, message, component); err != nil {
+							return
+						}
+					}
+				}
+			}
+		},
+	)
+}
+
+// sendEvent renders a component into a single SSE frame, named and identified like the message it represents, and
+// flushes it to the client. Errors are logged already.
+func sendEvent(ctx context.Context, w http.ResponseWriter, message realtime.Message, component templ.Component) error {
+	rendered := &bytes.Buffer{}
+	err := component.Render(ctx, rendered)
+	if err != nil {
+		slog.ErrorContext(ctx, "Unable to render response.", "error", err)
+		return err
+	}
+
+	frame := &strings.Builder{}
+	fmt.Fprintf(frame, "id: %s\n", message.ID)
+	fmt.Fprintf(frame, "event: %s\n", message.Event.Name())
+	for _, line := range strings.Split(rendered.String(), "\n") {
+		fmt.Fprintf(frame, "data: %s\n", line)
+	}
+	frame.WriteString("\n")
+
+	_, err = w.Write([]byte(frame.String()))
+	if err != nil {
+		slog.ErrorContext(ctx, "Unable to send SSE frame.", "error", err)
+		return err
+	}
+	w.(http.Flusher).Flush()
+	return nil
+}
@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/debugloop/wunschkonzert/pkg/i18n"
+)
+
+// LocaleHandler returns the handler persisting a user's choice of language in a cookie. It redirects back to the main
+// page afterwards.
+func LocaleHandler(catalog *i18n.Catalog) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			loc, ok := catalog.Lookup(req.URL.Query().Get("lang"))
+			if !ok {
+				http.Error(w, "Unknown language", http.StatusBadRequest)
+				return
+			}
+
+			http.SetCookie(w, &http.Cookie{
+				Name:     i18n.CookieName,
+				Value:    loc.Tag,
+				Path:     "/",
+				Expires:  time.Now().AddDate(1, 0, 0),
+				SameSite: http.SameSiteLaxMode,
+				HttpOnly: true,
+			})
+			http.Redirect(w, req, "/", http.StatusSeeOther)
+		},
+	)
+}
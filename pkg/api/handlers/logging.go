@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+// LogLevelHandler returns the handler showing and changing the log level at runtime. A new level is set by sending its
+// name, e.g. "debug", in the level form value.
+func LogLevelHandler(level *slog.LevelVar) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			if req.Method == http.MethodPost || req.Method == http.MethodPut {
+				var newLevel slog.Level
+				if err := newLevel.UnmarshalText([]byte(req.FormValue("level"))); err != nil {
+					http.Error(w, "Unknown level", http.StatusBadRequest)
+					return
+				}
+				level.Set(newLevel)
+				slog.WarnContext(req.Context(), "Changed log level.", "level", newLevel)
+			}
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			_, _ = fmt.Fprintln(w, level.Level())
+		},
+	)
+}
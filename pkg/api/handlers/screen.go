@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/a-h/templ"
+
+	"github.com/debugloop/wunschkonzert/pkg/assets"
+	"github.com/debugloop/wunschkonzert/pkg/requests"
+	spotifylib "github.com/debugloop/wunschkonzert/pkg/spotify"
+	"github.com/debugloop/wunschkonzert/pkg/ui"
+)
+
+// screenQueueLength is the number of upcoming songs shown on the big screen.
+const screenQueueLength = 5
+
+// ScreenHandler returns the handler rendering the big screen page. Guests can join using a QR code pointing at the
+// given URL.
+func ScreenHandler(bundle *assets.Bundle, event *ui.Event, joinURL string) http.Handler {
+	return templ.Handler(ui.Screen(bundle, event, joinURL))
+}
+
+// upcomingSongs picks the first few songs from the queue and attributes them to the guests who requested them.
+func upcomingSongs(queue *spotifylib.Queue, history *requests.History) []ui.QueuedSong {
+	upcoming := []ui.QueuedSong{}
+	for _, song := range queue.Songs {
+		if len(upcoming) == screenQueueLength {
+			break
+		}
+		guest, _ := history.Requester(song.URI)
+		upcoming = append(upcoming, ui.QueuedSong{Song: song, Guest: guest})
+	}
+	return upcoming
+}
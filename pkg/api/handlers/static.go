@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/debugloop/wunschkonzert/pkg/assets"
+)
+
+// StaticHandler returns the handler serving embedded assets. As their paths contain a content hash, they can be cached
+// indefinitely.
+func StaticHandler(bundle *assets.Bundle) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			asset, ok := bundle.Lookup(req.URL.Path)
+			if !ok {
+				http.NotFound(w, req)
+				return
+			}
+
+			w.Header().Set("Content-Type", asset.ContentType)
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+			w.Header().Set("ETag", `"`+path.Base(asset.URL)+`"`)
+			http.ServeContent(w, req, "", time.Time{}, bytes.NewReader(asset.Content))
+		},
+	)
+}
+
+// FileHandler returns a handler serving a single local file, e.g. a logo.
+func FileHandler(filePath string) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			http.ServeFile(w, req, filePath)
+		},
+	)
+}
@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"context"
+	"io"
+
+	"github.com/a-h/templ"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// tracer is used to trace the parts of handling requests not covered by the spans of otelhttp.
+var tracer = otel.Tracer("github.com/debugloop/wunschkonzert/pkg/api/handlers")
+
+// render renders a component within its own span, so slow rendering can be told apart from slow spotify requests.
+func render(ctx context.Context, w io.Writer, name string, component templ.Component) error {
+	ctx, span := tracer.Start(ctx, "render "+name)
+	defer span.End()
+	err := component.Render(ctx, w)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "rendering failed")
+	}
+	return err
+}
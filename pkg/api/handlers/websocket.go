@@ -0,0 +1,264 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+
+	"github.com/a-h/templ"
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+
+	"github.com/debugloop/wunschkonzert/pkg/api"
+	"github.com/debugloop/wunschkonzert/pkg/realtime"
+	"github.com/debugloop/wunschkonzert/pkg/requests"
+)
+
+const (
+	// socketWriteTimeout is how long a client may take to accept a message before it is considered stalled.
+	socketWriteTimeout = 5 * time.Second
+	// socketReadLimit is the largest message accepted from clients, which is plenty for any action.
+	socketReadLimit = 4096
+	// socketActionRate is how many actions a client may send per second, further actions are dropped.
+	socketActionRate = 5
+	// voterCookie identifies a guest's browser, so they count once per song regardless of how often they connect.
+	voterCookie = "voter"
+)
+
+// The types of messages sent over a socket. Events are sent using their own names as type.
+const (
+	socketHeartbeat = "heartbeat"
+	socketVote      = "vote"
+	socketReaction  = "reaction"
+	socketError     = "error"
+)
+
+// trackURI matches the URIs of spotify tracks, the only thing guests may vote on.
+var trackURI = regexp.MustCompile(`^spotify:track:[0-9A-Za-z]{22}$`)
+
+// reactions are the emoji guests may react with.
+var reactions = map[string]bool{
+	"🎉":  true,
+	"❤️": true,
+	"🔥":  true,
+	"👏":  true,
+	"💃":  true,
+}
+
+// socketMessage frames everything sent over a socket, in both directions. Events carry their rendered HTML, if any, and
+// their JSON representation. Actions sent by clients use the remaining fields as needed.
+type socketMessage struct {
+	Type  string          `json:"type"`
+	ID    string          `json:"id,omitempty"`
+	HTML  string          `json:"html,omitempty"`
+	Data  json.RawMessage `json:"data,omitempty"`
+	Song  string          `json:"song,omitempty"`
+	Value int             `json:"value,omitempty"`
+	Emoji string          `json:"emoji,omitempty"`
+	Error string          `json:"error,omitempty"`
+	Retry int64           `json:"retry,omitempty"`
+}
+
+// NowPlayingSocketHandler is the WebSocket counterpart of NowPlayingLiveHandler. Besides receiving what is playing,
+// guests can vote on requested songs and react to what is playing.
+func NowPlayingSocketHandler(bus *realtime.Bus, history *requests.History, votes *requests.Votes, serverName string) http.Handler {
+	return socketHandler(bus, history, votes, serverName, nowPlayingRenderer(),
+		realtime.EventNowPlaying, realtime.EventVoteChanged, realtime.EventReaction)
+}
+
+// ScreenSocketHandler is the WebSocket counterpart of ScreenLiveHandler. It additionally receives votes and reactions,
+// so the big screen can show them.
+func ScreenSocketHandler(bus *realtime.Bus, history *requests.History, votes *requests.Votes, serverName string) http.Handler {
+	return socketHandler(bus, history, votes, serverName, screenRenderer(history),
+		realtime.EventNowPlaying, realtime.EventQueueChanged, realtime.EventRequestAdded, realtime.EventVoteChanged,
+		realtime.EventReaction)
+}
+
+// socketHandler subscribes to the named events and sends them as JSON messages, while accepting actions from the
+// client. Like with SSE, clients resume using the ID of the last message received, passed as the "last" query
+// parameter. Clients which do not keep up only ever miss intermediate states, and are disconnected if they stall. When
+// the server shuts down, clients are told how long to wait before reconnecting.
+func socketHandler(bus *realtime.Bus, history *requests.History, votes *requests.Votes, serverName string, render func(realtime.Event) templ.Component, names ...string) http.Handler {
+	origins := []string{}
+	if u, err := url.Parse(serverName); err == nil && u.Host != "" {
+		origins = append(origins, u.Host)
+	}
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			voter := voterID(w, req)
+			conn, err := websocket.Accept(w, req, &websocket.AcceptOptions{
+				OriginPatterns: origins,
+			})
+			if err != nil {
+				slog.WarnContext(req.Context(), "Unable to accept socket.", "error", err)
+				return
+			}
+			defer func() {
+				_ = conn.CloseNow()
+			}()
+			conn.SetReadLimit(socketReadLimit)
+
+			ctx, cancel := context.WithCancel(req.Context())
+			defer cancel()
+			go func() {
+				defer cancel()
+				readActions(ctx, conn, bus, history, votes, voter)
+			}()
+
+			heartbeat := time.NewTicker(sseHeartbeat)
+			defer heartbeat.Stop()
+
+			sub := bus.Subscribe(ctx, req.URL.Query().Get("last"), names...)
+			defer bus.Unsubscribe(req.Context(), sub)
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-sub.Done():
+					_ = conn.Close(websocket.StatusTryAgainLater, "unresponsive")
+					return
+				case <-api.Draining(req.Context()):
+					_ = writeMessage(ctx, conn, socketMessage{Type: reconnectEvent, Retry: drainRetry.Milliseconds()})
+					_ = conn.Close(websocket.StatusGoingAway, "shutting down")
+					return
+				case <-heartbeat.C:
+					pingCtx, pingCancel := context.WithTimeout(ctx, socketWriteTimeout)
+					err := conn.Ping(pingCtx)
+					pingCancel()
+					if err != nil {
+						return
+					}
+				case <-sub.Ready():
+					for _, message := range sub.Receive() {
+						if err := sendMessage(ctx, conn, message, render(message.Event)); err != nil {
+							return
+						}
+					}
+				}
+			}
+		},
+	)
+}
+
+// readActions handles actions sent by the client until the connection breaks. Votes are cast on behalf of the given
+// voter, on songs which have been requested. Malformed messages are answered with an error, but do not end the
+// connection. Either counts towards the rate limit.
+func readActions(ctx context.Context, conn *websocket.Conn, bus *realtime.Bus, history *requests.History, votes *requests.Votes, voter string) {
+	window := time.Now()
+	actions := 0
+	for {
+		_, data, err := conn.Read(ctx)
+		if err != nil {
+			return
+		}
+
+		if time.Since(window) >= time.Second {
+			window = time.Now()
+			actions = 0
+		}
+		if actions++; actions > socketActionRate {
+			continue
+		}
+
+		var action socketMessage
+		if err := json.Unmarshal(data, &action); err != nil {
+			if err := writeMessage(ctx, conn, socketMessage{Type: socketError, Error: "malformed message"}); err != nil {
+				return
+			}
+			continue
+		}
+
+		switch action.Type {
+		case socketHeartbeat:
+			err = writeMessage(ctx, conn, socketMessage{Type: socketHeartbeat})
+		case socketVote:
+			if !trackURI.MatchString(action.Song) || !history.Requested(action.Song) {
+				err = writeMessage(ctx, conn, socketMessage{Type: socketError, Error: "unknown song"})
+				break
+			}
+			votes.Cast(action.Song, voter, action.Value, func(tally int) {
+				bus.Publish(ctx, realtime.VoteChanged{SongURI: action.Song, Votes: tally})
+			})
+		case socketReaction:
+			if !reactions[action.Emoji] {
+				err = writeMessage(ctx, conn, socketMessage{Type: socketError, Error: "unknown reaction"})
+				break
+			}
+			bus.Broadcast(ctx, realtime.Reaction{Emoji: action.Emoji})
+		default:
+			err = writeMessage(ctx, conn, socketMessage{Type: socketError, Error: "unknown action"})
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// sendMessage frames a message, along with its rendered component if there is one, and sends it to the client. Errors
+// are logged already.
+func sendMessage(ctx context.Context, conn *websocket.Conn, message realtime.Message, component templ.Component) error {
+	frame := socketMessage{
+		Type: message.Event.Name(),
+		ID:   message.ID,
+	}
+	if component != nil {
+		rendered := &bytes.Buffer{}
+		if err := component.Render(ctx, rendered); err != nil {
+			slog.ErrorContext(ctx, "Unable to render response.", "error", err)
+			return err
+		}
+		frame.HTML = rendered.String()
+	}
+	data, err := json.Marshal(message.Event)
+	if err != nil {
+		slog.ErrorContext(ctx, "Unable to encode event.", "error", err)
+		return err
+	}
+	frame.Data = data
+
+	if err := writeMessage(ctx, conn, frame); err != nil {
+		slog.WarnContext(ctx, "Unable to send socket message.", "error", err)
+		return err
+	}
+	return nil
+}
+
+// writeMessage sends a single message, giving up if the client does not accept it in time.
+func writeMessage(ctx context.Context, conn *websocket.Conn, message socketMessage) error {
+	ctx, cancel := context.WithTimeout(ctx, socketWriteTimeout)
+	defer cancel()
+	return wsjson.Write(ctx, conn, message)
+}
+
+// voterID returns the identifier of the guest's browser, for votes to be cast on behalf of. Browsers without one are
+// assigned a random identifier, which is remembered in a cookie.
+func voterID(w http.ResponseWriter, req *http.Request) string {
+	if cookie, err := req.Cookie(voterCookie); err == nil && validVoterID(cookie.Value) {
+		return cookie.Value
+	}
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	voter := hex.EncodeToString(id)
+	http.SetCookie(w, &http.Cookie{
+		Name:     voterCookie,
+		Value:    voter,
+		Path:     "/",
+		Expires:  time.Now().AddDate(0, 0, 7),
+		SameSite: http.SameSiteLaxMode,
+		HttpOnly: true,
+	})
+	return voter
+}
+
+// validVoterID reports whether a voter identifier has the shape of those assigned by voterID.
+func validVoterID(voter string) bool {
+	id, err := hex.DecodeString(voter)
+	return err == nil && len(id) == 8
+}
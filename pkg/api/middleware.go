@@ -0,0 +1,107 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"strings"
+
+	"github.com/a-h/templ"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Middleware wraps a handler, adding behavior to all requests it handles.
+type Middleware func(http.Handler) http.Handler
+
+// Chain combines middlewares into one, so stacks can be defined once and used by several servers. The first middleware
+// given is the outermost one.
+func Chain(middlewares ...Middleware) Middleware {
+	return func(next http.Handler) http.Handler {
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			next = middlewares[i](next)
+		}
+		return next
+	}
+}
+
+// Recover is a middleware which turns panics in handlers into internal server errors, rather than dropping the
+// connection. Requests made by htmx receive the fallback fragment, if any, as it is swapped into the page in place of
+// whatever was requested. All other requests receive a plain error.
+func Recover(fallback templ.Component) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(
+			func(w http.ResponseWriter, req *http.Request) {
+				defer func() {
+					recovered := recover()
+					if recovered == nil {
+						return
+					}
+					if recovered == http.ErrAbortHandler {
+						// Aborting is how handlers deliberately drop a connection, the server takes care of it.
+						panic(recovered)
+					}
+					err := fmt.Errorf("panic: %v", recovered)
+					span := trace.SpanFromContext(req.Context())
+					span.RecordError(err, trace.WithStackTrace(true))
+					span.SetStatus(codes.Error, err.Error())
+					slog.ErrorContext(req.Context(), "Recovered from panic in handler.", "error", err, "stack", string(debug.Stack()))
+
+					if fallback == nil || req.Header.Get("HX-Request") != "true" {
+						http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+						return
+					}
+					w.Header().Set("Content-Type", "text/html; charset=utf-8")
+					w.WriteHeader(http.StatusInternalServerError)
+					if err := fallback.Render(req.Context(), w); err != nil {
+						slog.ErrorContext(req.Context(), "Unable to render or send response.", "error", err)
+					}
+				}()
+				next.ServeHTTP(w, req)
+			},
+		)
+	}
+}
+
+// SecurityHeaders is a middleware setting headers which keep pages from being framed, keep URLs from leaking to other
+// sites and restrict where content may be loaded from. Scripts are only allowed from this server, the given origins
+// and inline if they carry the nonce of the request, which templ components retrieve using templ.GetNonce. Styles,
+// fonts, images and media may come from any https origin, as events can configure their own.
+func SecurityHeaders(scriptOrigins ...string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(
+			func(w http.ResponseWriter, req *http.Request) {
+				nonce := newNonce()
+				scripts := append([]string{"'self'", "'nonce-" + nonce + "'"}, scriptOrigins...)
+				policy := []string{
+					"default-src 'self'",
+					"script-src " + strings.Join(scripts, " "),
+					// htmx adds its indicator styles inline, and templates use style attributes.
+					"style-src 'self' 'unsafe-inline' https:",
+					"font-src 'self' https: data:",
+					"img-src 'self' https: data:",
+					"media-src 'self' https:",
+					"connect-src 'self'",
+					"base-uri 'self'",
+					"form-action 'self'",
+					"frame-ancestors 'none'",
+				}
+				w.Header().Set("Content-Security-Policy", strings.Join(policy, "; "))
+				w.Header().Set("X-Frame-Options", "DENY")
+				w.Header().Set("X-Content-Type-Options", "nosniff")
+				w.Header().Set("Referrer-Policy", "strict-origin-when-cross-origin")
+				next.ServeHTTP(w, req.WithContext(templ.WithNonce(req.Context(), nonce)))
+			},
+		)
+	}
+}
+
+// newNonce returns a random value allowing inline scripts for a single response.
+func newNonce() string {
+	nonce := make([]byte, 16)
+	_, _ = rand.Read(nonce)
+	return base64.StdEncoding.EncodeToString(nonce)
+}
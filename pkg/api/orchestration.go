@@ -2,38 +2,238 @@ package api
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
 	"golang.org/x/sync/errgroup"
 )
 
-// OrchestratedServer is a interface for longer running processes manageable by the run method. It is used for API servers.
-type OrchestratedServer interface {
-	Run() error
-	Shutdown(time.Duration)
+// Component is a long running part of the app, like a server or a background worker, managed by an Orchestrator.
+type Component struct {
+	Name string
+	// Run runs the component until its context is canceled, in which case it should return nil. Returning before that
+	// means the component has crashed, or finished if it returns nil. Run calls ready once it is able to serve the
+	// components depending on it.
+	Run func(ctx context.Context, ready func()) error
+	// Stop is called to stop the component within the given grace period, instead of just canceling its context. This
+	// is optional and used for servers, which drain their connections.
+	Stop func(grace time.Duration)
+	// DependsOn lists the components which must have become ready before this one is started, and which are only
+	// stopped after this one has stopped.
+	DependsOn []*Component
+	// Restart decides whether and when a crashed component is restarted. Without restarts, a crash stops the app.
+	Restart RestartPolicy
+
+	setup     sync.Once
+	readyOnce sync.Once
+	ready     chan struct{}
+	done      chan struct{}
+	stopped   chan struct{}
+	running   atomic.Bool
+	ctx       context.Context
+	cancel    context.CancelFunc
+}
+
+// RestartPolicy describes how a crashed component is restarted. The zero value never restarts.
+type RestartPolicy struct {
+	// Attempts is how often a component is restarted in a row before giving up. Crashes after having run for longer
+	// than MaxBackoff do not count as in a row.
+	Attempts int
+	// Backoff is the delay before the first restart. It doubles with each restart in a row, up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// NewComponent returns a component running the given function, which is considered ready as soon as it started.
+func NewComponent(name string, run func(ctx context.Context) error, dependsOn ...*Component) *Component {
+	return &Component{
+		Name: name,
+		Run: func(ctx context.Context, ready func()) error {
+			ready()
+			return run(ctx)
+		},
+		DependsOn: dependsOn,
+	}
+}
+
+// Ready reports whether the component is running and ready to serve. It is not while a crashed component waits to be
+// restarted.
+func (c *Component) Ready() bool {
+	return c.running.Load()
+}
+
+// init prepares the component to be run.
+func (c *Component) init(ctx context.Context) {
+	c.setup.Do(func() {
+		c.ready = make(chan struct{})
+		c.done = make(chan struct{})
+		c.stopped = make(chan struct{})
+		// Components are canceled in dependency order during shutdown, not all at once along with the parent.
+		c.ctx, c.cancel = context.WithCancel(context.WithoutCancel(ctx))
+	})
+}
+
+// Orchestrator runs components in the order of their dependencies and stops them in reverse.
+type Orchestrator struct {
+	grace      time.Duration
+	components []*Component
+
+	restartMetric metric.Int64Counter
 }
 
-// Orchestrate can run multiple OrchestratedServer instances. It will handle their shutdown based on the context
-// parameter.
-func Orchestrate(ctx context.Context, grace time.Duration, servers ...OrchestratedServer) *errgroup.Group {
+// NewOrchestrator returns a new Orchestrator. Components which stop gracefully are given the grace period to do so.
+func NewOrchestrator(grace time.Duration, components ...*Component) *Orchestrator {
+	meter := otel.GetMeterProvider().Meter("github.com/debugloop/wunschkonzert/pkg/api")
+	restarts, err := meter.Int64Counter(
+		"orchestration.restart.count",
+		metric.WithDescription("The number of restarts of crashed components."),
+	)
+	if err != nil {
+		slog.Error("Problem setting up otel instrumentation.", "error", err)
+	}
+	return &Orchestrator{
+		grace:         grace,
+		components:    components,
+		restartMetric: restarts,
+	}
+}
+
+// Components returns all components managed by this Orchestrator.
+func (o *Orchestrator) Components() []*Component {
+	return o.components
+}
+
+// Ready reports whether all components are ready.
+func (o *Orchestrator) Ready() bool {
+	for _, c := range o.components {
+		if !c.Ready() {
+			return false
+		}
+	}
+	return true
+}
+
+// Run starts all components once their dependencies are ready. It stops them once the context is canceled or any
+// of them has crashed for good, and returns the first crash after all components have stopped.
+func (o *Orchestrator) Run(ctx context.Context) error {
+	for _, c := range o.components {
+		c.init(ctx)
+	}
+
 	eg, groupCtx := errgroup.WithContext(ctx)
+	for _, c := range o.components {
+		eg.Go(func() error {
+			defer close(c.done)
+			return o.run(c)
+		})
+	}
 
 	go func() {
+		<-groupCtx.Done()
+		if ctx.Err() != nil {
+			slog.Info("Initiating requested shutdown of all components.")
+		} else {
+			slog.Warn("Initiating shutdown of remaining components after at least one crashed.")
+		}
+		o.stop()
+	}()
+
+	err := eg.Wait()
+	// Servers return as soon as they stop listening, but are only done once their connections have drained.
+	for _, c := range o.components {
+		<-c.stopped
+	}
+	return err
+}
+
+// run waits for the dependencies of a component, then runs it until it stops, restarting it according to its policy.
+func (o *Orchestrator) run(c *Component) error {
+	for _, dependency := range c.DependsOn {
 		select {
-		case <-ctx.Done():
-			slog.Info("Initiating requested shutdown for servers.")
-		case <-groupCtx.Done():
-			slog.Warn("Initiating shutdown for remaining servers after at least one exited with an error.")
+		case <-c.ctx.Done():
+			return nil
+		case <-dependency.done:
+			return fmt.Errorf("%s: dependency %s stopped", c.Name, dependency.Name)
+		case <-dependency.ready:
+		}
+	}
+
+	restarts := 0
+	for {
+		if c.ctx.Err() != nil {
+			return nil
 		}
-		for _, srv := range servers {
-			go srv.Shutdown(grace)
+		slog.Info("Starting component.", "name", c.Name)
+		start := time.Now()
+		err := runSafely(c)
+		if c.ctx.Err() != nil {
+			return nil
+		}
+		if err == nil {
+			slog.Info("Component finished.", "name", c.Name)
+			return nil
 		}
-		slog.Info("Triggered shutdown on all servers.", "grace-period", grace)
-	}()
 
-	for _, srv := range servers {
-		eg.Go(srv.Run)
+		if time.Since(start) > c.Restart.MaxBackoff {
+			restarts = 0
+		}
+		if restarts >= c.Restart.Attempts {
+			slog.Error("Component crashed.", "name", c.Name, "error", err)
+			return fmt.Errorf("%s: %w", c.Name, err)
+		}
+		delay := min(c.Restart.Backoff<<restarts, c.Restart.MaxBackoff)
+		restarts++
+		slog.Warn("Component crashed, restarting.", "name", c.Name, "error", err, "retry-in", delay)
+		o.restartMetric.Add(c.ctx, 1, metric.WithAttributes(attribute.String("component", c.Name)))
+
+		select {
+		case <-c.ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+	}
+}
+
+// stop stops all components, each one only after all components depending on it have stopped.
+func (o *Orchestrator) stop() {
+	for _, c := range o.components {
+		go func() {
+			defer close(c.stopped)
+			for _, dependent := range o.components {
+				for _, dependency := range dependent.DependsOn {
+					if dependency == c {
+						<-dependent.done
+						<-dependent.stopped
+					}
+				}
+			}
+			c.cancel()
+			if c.Stop != nil {
+				c.Stop(o.grace)
+			}
+		}()
 	}
-	return eg
+	slog.Info("Triggered shutdown of all components.", "grace-period", o.grace)
+}
+
+// runSafely runs a component once, turning a panic into a crash.
+func runSafely(c *Component) (err error) {
+	defer func() {
+		c.running.Store(false)
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return c.Run(c.ctx, func() {
+		c.running.Store(true)
+		c.readyOnce.Do(func() {
+			close(c.ready)
+		})
+	})
 }
@@ -5,26 +5,68 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
+	"sync"
 	"time"
 
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
 )
 
+// drainingKey is the context key under which a server exposes its draining channel to handlers.
+type drainingKey struct{}
+
+// Draining returns a channel which is closed once the server handling the request is shutting down. Handlers keeping
+// connections open, like streams and sockets, should tell their clients to reconnect later and return. The channel is
+// nil, and thus never closed, outside of requests handled by a Server.
+func Draining(ctx context.Context) <-chan struct{} {
+	draining, _ := ctx.Value(drainingKey{}).(chan struct{})
+	return draining
+}
+
 // Server is a implementation of StartStopServer which embeds a http.Server.
 type Server struct {
-	Name       string
-	listenAddr string
-	mux        *http.ServeMux
+	Name        string
+	listenAddr  string
+	mux         *http.ServeMux
+	middlewares []Middleware
+	// mu ensures the http.Server is either set up before draining starts, or not at all.
+	mu         sync.Mutex
 	httpServer http.Server
+	draining   chan struct{}
+
+	shutdownMetric         metric.Int64Counter
+	shutdownDurationMetric metric.Float64Histogram
 }
 
 // NewServer returns a new http.Server that implements StartStopServer.
 func NewServer(name string, listenAddr string) *Server {
+	meter := otel.GetMeterProvider().Meter("github.com/debugloop/wunschkonzert/pkg/api")
+	shutdowns, err := meter.Int64Counter(
+		"server.shutdown.count",
+		metric.WithDescription("The number of server shutdowns, by whether all connections drained in time or were closed forcefully."),
+	)
+	if err != nil {
+		slog.Error("Problem setting up otel instrumentation.", "error", err)
+	}
+	shutdownDuration, err := meter.Float64Histogram(
+		"server.shutdown.duration",
+		metric.WithDescription("The time taken to shut down a server."),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		slog.Error("Problem setting up otel instrumentation.", "error", err)
+	}
 	return &Server{
-		Name:       name,
-		listenAddr: listenAddr,
-		mux:        http.NewServeMux(),
+		Name:                   name,
+		listenAddr:             listenAddr,
+		mux:                    http.NewServeMux(),
+		draining:               make(chan struct{}),
+		shutdownMetric:         shutdowns,
+		shutdownDurationMetric: shutdownDuration,
 	}
 }
 
@@ -37,28 +79,68 @@ func (s *Server) Handle(pattern string, handler http.Handler) {
 	)
 }
 
-// Run the Server with ListenAndServe. It is supposed to be called from a go routine.
-func (s *Server) Run() error {
+// Use adds middlewares wrapping all handlers of this server, so each server picks its own stack. The first middleware
+// given is the outermost one, right inside the otel handler. It must be called before the server is started.
+func (s *Server) Use(middlewares ...Middleware) {
+	s.middlewares = append(s.middlewares, middlewares...)
+}
+
+// Component returns a component running this server, which is ready once it is listening. It drains its connections
+// when stopped.
+func (s *Server) Component(dependsOn ...*Component) *Component {
+	return &Component{
+		Name: s.Name + "-server",
+		Run: func(_ context.Context, ready func()) error {
+			return s.serve(ready)
+		},
+		Stop:      s.Shutdown,
+		DependsOn: dependsOn,
+	}
+}
+
+// serve listens and serves until the server is shut down, calling ready once it is listening.
+func (s *Server) serve(ready func()) error {
+	s.mu.Lock()
+	select {
+	case <-s.draining:
+		s.mu.Unlock()
+		return nil
+	default:
+	}
+	handler := Chain(s.middlewares...)(s.mux)
 	s.httpServer = http.Server{
 		Addr:    s.listenAddr,
-		Handler: otelhttp.NewHandler(s.mux, s.Name),
+		Handler: otelhttp.NewHandler(handler, s.Name),
+		BaseContext: func(net.Listener) context.Context {
+			return context.WithValue(context.Background(), drainingKey{}, s.draining)
+		},
 	}
-	slog.Info("Listening.", "name", s.Name, "address", s.httpServer.Addr)
-	if err := s.httpServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+	s.mu.Unlock()
+	listener, err := net.Listen("tcp", s.listenAddr)
+	if err != nil {
 		return fmt.Errorf("listen: %w", err)
 	}
+	slog.Info("Listening.", "name", s.Name, "address", s.httpServer.Addr)
+	ready()
+	if err := s.httpServer.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("serve: %w", err)
+	}
 	return nil
 }
 
-// Shutdown signals the server to terminate, ending the go routine around Run.
+// Shutdown signals the server to terminate, ending the component serving it.
 func (s *Server) Shutdown(grace time.Duration) {
+	start := time.Now()
 	if grace == 0 {
 		slog.Info("Shutting down with immediate stop.", "name", s.Name)
 		s.Stop()
+		s.recordShutdown("immediate", start)
 		return
 	}
 
 	slog.Info("Shutting down.", "name", s.Name, "grace", grace)
+	// Tell long-lived connections to go, as http.Server.Shutdown would otherwise wait for them until the deadline.
+	s.drain()
 
 	deadline, deadlineCancel := context.WithTimeout(
 		context.Background(),
@@ -71,11 +153,16 @@ func (s *Server) Shutdown(grace time.Duration) {
 	if errors.Is(err, context.DeadlineExceeded) {
 		slog.Info("Deadline exceeded, stopping now.", "name", s.Name)
 		s.Stop()
+		s.recordShutdown("forced", start)
+		return
 	}
+	s.recordShutdown("drained", start)
 }
 
-// Stop signals an immediate stop, ending the go routine around Run.
+// Stop signals an immediate stop, ending the component serving it.
 func (s *Server) Stop() {
+	// Connections taken over by handlers, like sockets, are not closed by the http.Server, so tell them to go.
+	s.drain()
 	err := s.httpServer.Close()
 	if err == nil {
 		slog.Info("Stopped, kthxbye.")
@@ -85,3 +172,24 @@ func (s *Server) Stop() {
 		slog.Error("Unclean termination of HTTP server.", "name", s.Name, "error", err)
 	}
 }
+
+// drain notifies all handlers observing Draining that the server is shutting down.
+func (s *Server) drain() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.draining:
+	default:
+		close(s.draining)
+	}
+}
+
+// recordShutdown records how a shutdown started at the given time ended. It is logged as well, as metrics recorded
+// while shutting down are unlikely to be collected.
+func (s *Server) recordShutdown(result string, start time.Time) {
+	duration := time.Since(start)
+	slog.Info("Shut down.", "name", s.Name, "result", result, "duration", duration)
+	attrs := metric.WithAttributes(attribute.String("server", s.Name), attribute.String("result", result))
+	s.shutdownMetric.Add(context.Background(), 1, attrs)
+	s.shutdownDurationMetric.Record(context.Background(), duration.Seconds(), attrs)
+}
@@ -0,0 +1,228 @@
+// Package assets provides the third party frontend assets, i.e. scripts, stylesheets and fonts. They can either be
+// served from the binary itself or be referenced on their respective CDNs.
+package assets
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"embed"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+)
+
+//go:generate go run ./internal/fetch -dir static
+
+//go:embed static
+var static embed.FS
+
+// Mode selects where the browser loads assets from.
+type Mode string
+
+const (
+	// ModeEmbedded serves all assets from the binary. All assets need to be fetched before building.
+	ModeEmbedded Mode = "embedded"
+	// ModeCDN references all assets on their CDN, guarded by their pinned subresource integrity.
+	ModeCDN Mode = "cdn"
+)
+
+// PathPrefix is the route all embedded assets are served below.
+const PathPrefix = "/static/"
+
+// Source describes a pinned asset. It is used to fetch assets into the static directory as well as to reference them on
+// their CDN.
+type Source struct {
+	// File is the name of the asset inside the static directory. It is also the name used to reference the asset.
+	File string
+	// URL is the location of the asset on its CDN.
+	URL string
+	// Integrity is the pinned subresource integrity of the asset at URL. It guards the asset in CDN mode and is verified
+	// when fetching and embedding. Scripts are required to be pinned, fonts taken from stylesheets can not be, as the
+	// stylesheets are generated.
+	Integrity string
+	// ContentType is sent along with the embedded asset.
+	ContentType string
+	// FromStylesheet denotes that URL is a stylesheet, and the asset is the first font referenced therein. Such assets
+	// can not be referenced directly in CDN mode, the stylesheet is used instead.
+	FromStylesheet bool
+}
+
+// Sources are all assets used by the ui. Changing these requires pinning their new integrity and running go generate,
+// which prints the integrity of assets not pinned yet.
+var Sources = []Source{
+	{
+		File:        "htmx.min.js",
+		URL:         "https://unpkg.com/htmx.org@2.0.4/dist/htmx.min.js",
+		Integrity:   "sha384-HGfztofotfshcF7+8n44JQL2oJmowVChPTg48S+jvZoztPfvwD79OC/LTtG6dMp+",
+		ContentType: "text/javascript; charset=utf-8",
+	},
+	{
+		File:        "htmx-ext-sse.js",
+		URL:         "https://unpkg.com/htmx-ext-sse@2.2.2",
+		Integrity:   "sha384-Y4gc0CK6Kg+hmulDc6rZPJu0tqvk7EWlih0Oh+2OkAi1ZDlCbBDCQEE2uVk472Ky",
+		ContentType: "text/javascript; charset=utf-8",
+	},
+	{
+		File:        "pico.min.css",
+		URL:         "https://cdn.jsdelivr.net/npm/@picocss/pico@2.0.6/css/pico.min.css",
+		ContentType: "text/css; charset=utf-8",
+	},
+	{
+		File:           "brittany-signature.woff",
+		URL:            "https://fonts.cdnfonts.com/css/brittany-signature",
+		ContentType:    "font/woff",
+		FromStylesheet: true,
+	},
+}
+
+// fontFaces maps font files to the family they provide. Embedded fonts are made available by a generated stylesheet.
+var fontFaces = map[string]string{
+	"brittany-signature.woff": "Brittany Signature",
+}
+
+// Asset is a single asset as referenced by the ui.
+type Asset struct {
+	// URL is where the browser should load this asset from.
+	URL string
+	// Integrity is the subresource integrity value for CDN assets. It is empty for embedded assets or if the CDN
+	// resource is not pinned.
+	Integrity   string
+	ContentType string
+	Content     []byte
+}
+
+// Bundle holds all assets and knows how to reference and serve them.
+type Bundle struct {
+	mode   Mode
+	byName map[string]*Asset
+	byPath map[string]*Asset
+}
+
+// New returns a Bundle for the given mode.
+func New(mode Mode) (*Bundle, error) {
+	if mode != ModeEmbedded && mode != ModeCDN {
+		return nil, fmt.Errorf("unknown asset mode %q", mode)
+	}
+
+	b := &Bundle{
+		mode:   mode,
+		byName: make(map[string]*Asset),
+		byPath: make(map[string]*Asset),
+	}
+
+	fontCSS := &strings.Builder{}
+	fontFallback := ""
+	for _, src := range Sources {
+		if src.FromStylesheet && fontFallback == "" {
+			fontFallback = src.URL
+		}
+
+		if mode == ModeCDN {
+			switch {
+			case src.FromStylesheet:
+			case src.Integrity == "" && strings.HasPrefix(src.ContentType, "text/css"):
+				slog.Warn("Stylesheet is not pinned, it is loaded without subresource integrity.", "asset", src.File)
+			case src.Integrity == "":
+				return nil, fmt.Errorf("asset %s is not pinned, add its integrity to assets.Sources", src.File)
+			}
+			b.byName[src.File] = &Asset{URL: src.URL, Integrity: src.Integrity}
+			continue
+		}
+
+		content, err := fs.ReadFile(static, path.Join("static", src.File))
+		if err != nil {
+			return nil, fmt.Errorf("asset %s has not been fetched, run go generate ./pkg/assets or use cdn mode: %w", src.File, err)
+		}
+		if src.Integrity != "" && Integrity(content) != src.Integrity {
+			return nil, fmt.Errorf("asset %s does not match its pinned integrity, run go generate ./pkg/assets", src.File)
+		}
+
+		asset := &Asset{
+			URL:         PathPrefix + hashedName(src.File, content),
+			ContentType: src.ContentType,
+			Content:     content,
+		}
+		b.byPath[asset.URL] = asset
+		b.byName[src.File] = asset
+
+		if family, ok := fontFaces[src.File]; ok {
+			fmt.Fprintf(fontCSS, "@font-face { font-family: '%s'; src: url('%s'); font-display: swap; }\n", family, asset.URL)
+		}
+	}
+
+	// Fonts are always referenced using a stylesheet, which either contains the embedded font or comes from the CDN.
+	fonts := &Asset{URL: fontFallback}
+	if fontCSS.Len() > 0 {
+		fonts = &Asset{
+			ContentType: "text/css; charset=utf-8",
+			Content:     []byte(fontCSS.String()),
+		}
+		fonts.URL = PathPrefix + hashedName("fonts.css", fonts.Content)
+		b.byPath[fonts.URL] = fonts
+	}
+	b.byName["fonts.css"] = fonts
+
+	return b, nil
+}
+
+// Mode returns the mode this bundle was created with.
+func (b *Bundle) Mode() Mode {
+	return b.mode
+}
+
+// URL returns the location of an asset by its file name.
+func (b *Bundle) URL(name string) string {
+	if asset, ok := b.byName[name]; ok {
+		return asset.URL
+	}
+	return ""
+}
+
+// Integrity returns the subresource integrity of an asset by its file name. It is empty if it should not be checked.
+func (b *Bundle) Integrity(name string) string {
+	if asset, ok := b.byName[name]; ok {
+		return asset.Integrity
+	}
+	return ""
+}
+
+// Origins returns the origins of all assets referenced on a CDN, which the browser needs to be allowed to load from.
+func (b *Bundle) Origins() []string {
+	var origins []string
+	for _, asset := range b.byName {
+		u, err := url.Parse(asset.URL)
+		if err != nil || u.Host == "" {
+			continue
+		}
+		if origin := u.Scheme + "://" + u.Host; !slices.Contains(origins, origin) {
+			origins = append(origins, origin)
+		}
+	}
+	slices.Sort(origins)
+	return origins
+}
+
+// Lookup returns the embedded asset served at the given path.
+func (b *Bundle) Lookup(urlPath string) (*Asset, bool) {
+	asset, ok := b.byPath[urlPath]
+	return asset, ok
+}
+
+// hashedName inserts a content hash into a file name, which makes it safe to cache forever.
+func hashedName(name string, content []byte) string {
+	sum := sha256.Sum256(content)
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + hex.EncodeToString(sum[:])[:12] + ext
+}
+
+// Integrity computes the subresource integrity value of an asset's content.
+func Integrity(content []byte) string {
+	sum := sha512.Sum384(content)
+	return "sha384-" + base64.StdEncoding.EncodeToString(sum[:])
+}
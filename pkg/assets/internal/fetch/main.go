@@ -0,0 +1,91 @@
+// Command fetch downloads all pinned assets into a directory, from which they will be embedded into the binary.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/debugloop/wunschkonzert/pkg/assets"
+)
+
+// fontURL matches the first woff font referenced in a stylesheet.
+var fontURL = regexp.MustCompile(`url\(['"]?([^'")]+\.woff2?)['"]?\)`)
+
+func main() {
+	dir := flag.String("dir", "static", "The directory to download assets to.")
+	flag.Parse()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	for _, src := range assets.Sources {
+		content, err := download(ctx, src.URL)
+		if err != nil {
+			slog.Error("Could not download asset.", "asset", src.File, "error", err)
+			os.Exit(1)
+		}
+
+		switch got := assets.Integrity(content); {
+		case src.FromStylesheet:
+		case src.Integrity == "":
+			slog.Warn("Asset is not pinned, add its integrity to assets.Sources.", "asset", src.File, "integrity", got)
+		case src.Integrity != got:
+			slog.Error("Asset does not match its pinned integrity.", "asset", src.File, "want", src.Integrity, "got", got)
+			os.Exit(1)
+		}
+
+		if src.FromStylesheet {
+			match := fontURL.FindSubmatch(content)
+			if match == nil {
+				slog.Error("Stylesheet does not reference a font.", "asset", src.File, "url", src.URL)
+				os.Exit(1)
+			}
+			base, _ := url.Parse(src.URL)
+			ref, err := base.Parse(string(match[1]))
+			if err != nil {
+				slog.Error("Stylesheet references an invalid font URL.", "asset", src.File, "error", err)
+				os.Exit(1)
+			}
+			content, err = download(ctx, ref.String())
+			if err != nil {
+				slog.Error("Could not download font.", "asset", src.File, "error", err)
+				os.Exit(1)
+			}
+		}
+
+		if err := os.WriteFile(filepath.Join(*dir, src.File), content, 0o644); err != nil {
+			slog.Error("Could not write asset.", "asset", src.File, "error", err)
+			os.Exit(1)
+		}
+		slog.Info("Fetched asset.", "asset", src.File, "bytes", len(content))
+	}
+}
+
+func download(ctx context.Context, target string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", target, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("received %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
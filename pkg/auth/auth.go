@@ -6,7 +6,11 @@ import (
 	"log/slog"
 	"net/http"
 	"os"
+	"sync"
+	"time"
 
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/spotify"
 )
@@ -14,12 +18,22 @@ import (
 // OAuthService implements everything needed to manage a spotify oauth process. It embeds the necessary config as well
 // as some functionality to persist and restore tokens (and especially the refresh token) from disk.
 type OAuthService struct {
+	sync.Mutex
 	appCtx           context.Context
 	config           *oauth2.Config
 	token            *oauth2.Token
 	tokenPersistPath string
+	changed          chan struct{}
 }
 
+const (
+	// refreshMargin is how long before its expiry a token is refreshed.
+	refreshMargin = time.Minute
+	// refreshRetry is the delay before retrying a failed refresh. It doubles with each failure, up to refreshRetryMax.
+	refreshRetry    = 5 * time.Second
+	refreshRetryMax = time.Minute
+)
+
 // NewOAuthService returns a new OAuthService.
 func NewOAuthService(appCtx context.Context, oauthRedirect string, clientID string, clientSecret string, tokenPersistPath string) *OAuthService {
 	newService := &OAuthService{
@@ -31,6 +45,7 @@ func NewOAuthService(appCtx context.Context, oauthRedirect string, clientID stri
 				"user-read-email",
 				"user-read-recently-played",
 				"user-read-currently-playing",
+				"user-read-playback-state",
 				"playlist-modify-public",
 				"playlist-modify-private",
 			},
@@ -38,18 +53,42 @@ func NewOAuthService(appCtx context.Context, oauthRedirect string, clientID stri
 			RedirectURL: oauthRedirect + "/spotify/callback",
 		},
 		tokenPersistPath: tokenPersistPath,
+		changed:          make(chan struct{}, 1),
 	}
 
 	if tokenPersistPath != "" {
 		newService.restoreToken()
 	}
 
+	meter := otel.GetMeterProvider().Meter("github.com/debugloop/wunschkonzert/pkg/auth")
+	_, err := meter.Float64ObservableGauge(
+		"auth.token.expiry",
+		metric.WithDescription("The time until the current token expires. It is not reported without an expiring token."),
+		metric.WithUnit("s"),
+		metric.WithFloat64Callback(func(_ context.Context, observer metric.Float64Observer) error {
+			if token := newService.Token(); token != nil && !token.Expiry.IsZero() {
+				observer.Observe(time.Until(token.Expiry).Seconds())
+			}
+			return nil
+		}),
+	)
+	if err != nil {
+		slog.Error("Problem setting up otel instrumentation.", "error", err)
+	}
+
 	return newService
 }
 
 // Transport returns a self-authenticating http.RoundTripper from this service.
 func (o *OAuthService) Transport() http.RoundTripper {
-	return o.config.Client(o.appCtx, o.token).Transport
+	return o.config.Client(o.appCtx, o.Token()).Transport
+}
+
+// Token returns the token currently in use, or nil if there is none yet.
+func (o *OAuthService) Token() *oauth2.Token {
+	o.Lock()
+	defer o.Unlock()
+	return o.token
 }
 
 // Config returns this service's embedded config. This is needed to implement handlers.
@@ -59,9 +98,15 @@ func (o *OAuthService) Config() *oauth2.Config {
 
 // UseToken receives a token which this service and it's Transports will use. It further persists the token to disk.
 func (o *OAuthService) UseToken(token *oauth2.Token) {
+	o.Lock()
 	o.token = token
+	o.Unlock()
+	select {
+	case o.changed <- struct{}{}:
+	default:
+	}
 
-	jsonToken, err := json.Marshal(o.token)
+	jsonToken, err := json.Marshal(token)
 	if err != nil {
 		slog.Error("Could not persist token.", "error", err)
 		return
@@ -78,6 +123,48 @@ func (o *OAuthService) UseToken(token *oauth2.Token) {
 	slog.Info("Persisted token to disk.")
 }
 
+// KeepFresh refreshes the token shortly before it expires and persists the refreshed one, so it is still valid after a
+// restart. It calls ready once there is a token, which may require the admin to log in first. Failed refreshes are
+// retried with backoff until they succeed or the admin logs in again. It blocks until the context is canceled.
+func (o *OAuthService) KeepFresh(ctx context.Context, ready func()) error {
+	retry := time.Duration(0)
+	for {
+		token := o.Token()
+		var refresh <-chan time.Time
+		if token != nil {
+			ready()
+			switch {
+			case retry > 0:
+				refresh = time.After(retry)
+			case !token.Expiry.IsZero():
+				refresh = time.After(time.Until(token.Expiry) - refreshMargin)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-o.changed:
+			retry = 0
+			continue
+		case <-refresh:
+		}
+
+		// Mark the token as expired to force the token source to refresh it.
+		expired := *token
+		expired.Expiry = time.Now()
+		fresh, err := o.config.TokenSource(ctx, &expired).Token()
+		if err != nil {
+			retry = min(max(2*retry, refreshRetry), refreshRetryMax)
+			slog.Error("Refreshing of token failed.", "error", err, "retry", retry)
+			continue
+		}
+		retry = 0
+		slog.Info("Refreshed token.", "expiry", fresh.Expiry)
+		o.UseToken(fresh)
+	}
+}
+
 func (o *OAuthService) restoreToken() {
 	tokenBytes, err := os.ReadFile(o.tokenPersistPath)
 	if err != nil {
@@ -0,0 +1,319 @@
+// Package catalog keeps a local copy of song metadata, so guests can still search when spotify is unreachable. It is
+// filled from seed playlists, the request history and previous searches.
+package catalog
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	spotifylib "github.com/debugloop/wunschkonzert/pkg/spotify"
+)
+
+// tracer is used to trace prefetching.
+var tracer = otel.Tracer("github.com/debugloop/wunschkonzert/pkg/catalog")
+
+// Catalog holds all songs known locally, along with a fuzzy index over them. It optionally persists them to disk, one
+// JSON object per line, so they survive restarts.
+type Catalog struct {
+	sync.RWMutex
+	songs       map[string]spotifylib.Song
+	order       []string
+	index       *index
+	persistPath string
+	pool        bool
+}
+
+// New returns a new Catalog. If persistPath is not empty, previously known songs are restored from it.
+func New(persistPath string) *Catalog {
+	c := &Catalog{
+		songs:       make(map[string]spotifylib.Song),
+		index:       newIndex(),
+		persistPath: persistPath,
+	}
+	if persistPath != "" {
+		c.restore()
+	}
+	return c
+}
+
+// NewPool returns a new Catalog which only ever contains the songs of the sources it is prefetched from. It is used to
+// restrict guests to a curated set of songs. If persistPath is not empty, the songs last fetched are restored from it,
+// so guests can pick songs even if spotify is unreachable at startup.
+func NewPool(persistPath string) *Catalog {
+	c := &Catalog{
+		songs:       make(map[string]spotifylib.Song),
+		index:       newIndex(),
+		persistPath: persistPath,
+		pool:        true,
+	}
+	if persistPath != "" {
+		c.restore()
+	}
+	return c
+}
+
+// Contains reports whether a song is known.
+func (c *Catalog) Contains(uri string) bool {
+	c.RLock()
+	defer c.RUnlock()
+	_, ok := c.songs[uri]
+	return ok
+}
+
+// Songs returns up to limit songs in the order they became known, which is the order of their sources for a pool.
+func (c *Catalog) Songs(limit uint) []spotifylib.Song {
+	c.RLock()
+	defer c.RUnlock()
+	songs := make([]spotifylib.Song, 0, min(int(limit), len(c.order)))
+	for _, uri := range c.order {
+		if uint(len(songs)) >= limit {
+			break
+		}
+		songs = append(songs, c.songs[uri])
+	}
+	return songs
+}
+
+// Len returns the number of songs known.
+func (c *Catalog) Len() int {
+	c.RLock()
+	defer c.RUnlock()
+	return len(c.songs)
+}
+
+// Add records songs which have not been known yet. Songs lacking details are ignored.
+func (c *Catalog) Add(songs ...spotifylib.Song) {
+	c.Lock()
+	defer c.Unlock()
+
+	added := []spotifylib.Song{}
+	for _, song := range songs {
+		if song.URI == "" || song.Name == "" {
+			continue
+		}
+		if _, ok := c.songs[song.URI]; ok {
+			continue
+		}
+		c.songs[song.URI] = song
+		c.order = append(c.order, song.URI)
+		c.index.add(song.URI, document(song))
+		added = append(added, song)
+	}
+
+	if c.persistPath == "" || len(added) == 0 {
+		return
+	}
+
+	f, err := os.OpenFile(c.persistPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		slog.Error("Opening of catalog failed.", "error", err)
+		return
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			slog.Error("Closing of catalog failed.", "error", err)
+		}
+	}()
+
+	w := bufio.NewWriter(f)
+	encoder := json.NewEncoder(w)
+	for _, song := range added {
+		if err := encoder.Encode(song); err != nil {
+			slog.Error("Writing of catalog failed.", "error", err)
+			return
+		}
+	}
+	if err := w.Flush(); err != nil {
+		slog.Error("Writing of catalog failed.", "error", err)
+	}
+}
+
+// Search returns up to limit songs matching the query, best matches first. Misspellings are tolerated. Field filters
+// are matched like free text, except for the release year. Genres are not known locally and thus ignored. A query
+// consisting of a release year only lists all songs of these years.
+func (c *Catalog) Search(query spotifylib.Query, limit uint) []spotifylib.Song {
+	c.RLock()
+	defer c.RUnlock()
+
+	text := query.Text + " " + query.Artist + " " + query.Track + " " + query.Album
+	uris := c.index.search(text)
+	if strings.TrimSpace(text) == "" && query.YearFrom != 0 {
+		uris = c.order
+	}
+
+	songs := []spotifylib.Song{}
+	for _, uri := range uris {
+		song := c.songs[uri]
+		if !inYears(song, query) {
+			continue
+		}
+		songs = append(songs, song)
+		if uint(len(songs)) >= limit {
+			break
+		}
+	}
+	return songs
+}
+
+// Prefetch adds the songs of the given sources to the catalog. It keeps doing so in the given interval, as playlists
+// are likely to change during an event. A pool drops songs no longer found in its sources, but only if all sources
+// could be fetched. It blocks until the context is canceled.
+func (c *Catalog) Prefetch(ctx context.Context, spotify *spotifylib.Client, market string, sources []Source, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		// Each round is traced on its own, as the loop runs for the lifetime of the app.
+		fetchCtx, span := tracer.Start(ctx, "catalog.prefetch", trace.WithNewRoot(), trace.WithAttributes(
+			attribute.Bool("pool", c.pool),
+			attribute.Int("sources", len(sources)),
+		))
+		songs := []spotifylib.Song{}
+		complete := true
+		for _, source := range sources {
+			fetched, err := source.fetch(fetchCtx, spotify, market)
+			if err != nil {
+				slog.WarnContext(fetchCtx, "Prefetching of catalog source failed.", "type", source.Type, "id", source.ID, "error", err)
+				span.RecordError(err)
+				complete = false
+			}
+			songs = append(songs, fetched...)
+		}
+		if c.pool && complete {
+			c.replace(songs)
+		} else {
+			c.Add(songs...)
+		}
+		span.SetAttributes(attribute.Int("songs", len(songs)), attribute.Bool("complete", complete))
+		span.End()
+		slog.Info("Prefetched catalog.", "count", c.Len(), "pool", c.pool)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// replace swaps all songs known for the given ones. The persisted songs are replaced as well.
+func (c *Catalog) replace(songs []spotifylib.Song) {
+	c.Lock()
+	defer c.Unlock()
+	defer c.rewrite()
+	c.songs = make(map[string]spotifylib.Song, len(songs))
+	c.order = nil
+	c.index = newIndex()
+	for _, song := range songs {
+		if song.URI == "" || song.Name == "" {
+			continue
+		}
+		if _, ok := c.songs[song.URI]; ok {
+			continue
+		}
+		c.songs[song.URI] = song
+		c.order = append(c.order, song.URI)
+		c.index.add(song.URI, document(song))
+	}
+}
+
+// rewrite persists all songs known, replacing those persisted before. The caller must hold the lock.
+func (c *Catalog) rewrite() {
+	if c.persistPath == "" {
+		return
+	}
+
+	tmpPath := c.persistPath + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		slog.Error("Opening of catalog failed.", "error", err)
+		return
+	}
+
+	w := bufio.NewWriter(f)
+	encoder := json.NewEncoder(w)
+	for _, uri := range c.order {
+		if err := encoder.Encode(c.songs[uri]); err != nil {
+			slog.Error("Writing of catalog failed.", "error", err)
+			_ = f.Close()
+			return
+		}
+	}
+	if err := errors.Join(w.Flush(), f.Close()); err != nil {
+		slog.Error("Writing of catalog failed.", "error", err)
+		return
+	}
+	if err := os.Rename(tmpPath, c.persistPath); err != nil {
+		slog.Error("Writing of catalog failed.", "error", err)
+	}
+}
+
+func (c *Catalog) restore() {
+	f, err := os.Open(c.persistPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		slog.Warn("Reading of catalog failed.", "error", err)
+		return
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		var song spotifylib.Song
+		if err := json.Unmarshal(scanner.Bytes(), &song); err != nil {
+			slog.Warn("Skipping unreadable song in catalog.", "error", err)
+			continue
+		}
+		if _, ok := c.songs[song.URI]; !ok {
+			c.order = append(c.order, song.URI)
+		}
+		c.songs[song.URI] = song
+		c.index.add(song.URI, document(song))
+	}
+	if err := scanner.Err(); err != nil {
+		slog.Warn("Reading of catalog failed.", "error", err)
+	}
+
+	slog.Info("Restored catalog.", "count", len(c.songs), "pool", c.pool)
+}
+
+// document returns the text a song is found by.
+func document(song spotifylib.Song) string {
+	text := song.Name + " " + song.Album.Name
+	for _, artist := range song.Artists {
+		text += " " + artist.Name
+	}
+	return text
+}
+
+// inYears reports whether a song was released in the years a query is restricted to.
+func inYears(song spotifylib.Song, query spotifylib.Query) bool {
+	if query.YearFrom == 0 {
+		return true
+	}
+	if len(song.Album.ReleaseDate) < 4 {
+		return false
+	}
+	year, err := time.Parse("2006", song.Album.ReleaseDate[:4])
+	if err != nil {
+		return false
+	}
+	to := max(query.YearTo, query.YearFrom)
+	return uint(year.Year()) >= query.YearFrom && uint(year.Year()) <= to
+}
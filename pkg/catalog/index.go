@@ -0,0 +1,100 @@
+package catalog
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// minScore is the share of a query's trigrams a document needs to contain to be considered a match. Anything lower
+// yields too much noise, anything higher stops tolerating typos.
+const minScore = 0.5
+
+// index is a trigram index supporting fuzzy full text search. It is not safe for concurrent use.
+type index struct {
+	trigrams map[string]map[string]struct{}
+}
+
+func newIndex() *index {
+	return &index{trigrams: make(map[string]map[string]struct{})}
+}
+
+// add indexes a document under the given id.
+func (i *index) add(id string, text string) {
+	for _, trigram := range trigrams(text) {
+		ids, ok := i.trigrams[trigram]
+		if !ok {
+			ids = make(map[string]struct{})
+			i.trigrams[trigram] = ids
+		}
+		ids[id] = struct{}{}
+	}
+}
+
+// search returns the ids of all documents matching the text, best matches first.
+func (i *index) search(text string) []string {
+	query := trigrams(text)
+	if len(query) == 0 {
+		return nil
+	}
+
+	hits := make(map[string]int)
+	for _, trigram := range query {
+		for id := range i.trigrams[trigram] {
+			hits[id]++
+		}
+	}
+
+	ids := []string{}
+	for id, count := range hits {
+		if float64(count)/float64(len(query)) >= minScore {
+			ids = append(ids, id)
+		}
+	}
+	slices.SortFunc(ids, func(a, b string) int {
+		return cmp.Or(cmp.Compare(hits[b], hits[a]), cmp.Compare(a, b))
+	})
+	return ids
+}
+
+// trigrams returns the distinct trigrams of all words in a text, after normalization. Words are padded, so that
+// matching beginnings and endings count more.
+func trigrams(text string) []string {
+	seen := make(map[string]struct{})
+	result := []string{}
+	for _, word := range strings.Fields(normalize(text)) {
+		padded := []rune(" " + word + " ")
+		for j := 0; j+3 <= len(padded); j++ {
+			trigram := string(padded[j : j+3])
+			if _, ok := seen[trigram]; !ok {
+				seen[trigram] = struct{}{}
+				result = append(result, trigram)
+			}
+		}
+	}
+	return result
+}
+
+// transliterations map letters to how guests are likely to type them on any keyboard, e.g. ä to ae.
+var transliterations = strings.NewReplacer(
+	"ä", "ae", "ö", "oe", "ü", "ue", "ß", "ss", "æ", "ae", "œ", "oe", "ø", "o", "å", "a",
+	"á", "a", "à", "a", "â", "a", "ã", "a",
+	"é", "e", "è", "e", "ê", "e", "ë", "e",
+	"í", "i", "ì", "i", "î", "i", "ï", "i",
+	"ó", "o", "ò", "o", "ô", "o", "õ", "o",
+	"ú", "u", "ù", "u", "û", "u",
+	"ç", "c", "ñ", "n", "ý", "y", "ÿ", "y",
+	"&", " and ",
+)
+
+// normalize lowercases text, transliterates diacritics and replaces anything but letters and digits with spaces.
+func normalize(text string) string {
+	text = transliterations.Replace(strings.ToLower(text))
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return ' '
+	}, text)
+}
@@ -0,0 +1,91 @@
+package catalog
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	spotifylib "github.com/debugloop/wunschkonzert/pkg/spotify"
+)
+
+// Source is a playlist or album songs are fetched from.
+type Source struct {
+	Type spotifylib.SearchType
+	ID   string
+}
+
+// ParseSources parses a comma separated list of links or URIs to playlists and albums. Plain IDs are taken to be
+// playlists.
+func ParseSources(list string) ([]Source, error) {
+	sources := []Source{}
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		kind, id, ok := spotifylib.ParseLink(item)
+		if !ok {
+			kind, id = spotifylib.SearchPlaylist, item
+		}
+		if kind != spotifylib.SearchPlaylist && kind != spotifylib.SearchAlbum {
+			return nil, fmt.Errorf("%s is neither a playlist nor an album", item)
+		}
+		sources = append(sources, Source{Type: kind, ID: id})
+	}
+	return sources, nil
+}
+
+// fetch returns all songs of this source. Any songs fetched are returned even if it fails, but only a source fetched
+// without an error is complete.
+func (s Source) fetch(ctx context.Context, spotify *spotifylib.Client, market string) ([]spotifylib.Song, error) {
+	if s.Type == spotifylib.SearchAlbum {
+		return s.fetchAlbum(ctx, spotify, market)
+	}
+
+	songs := []spotifylib.Song{}
+	var offset uint
+	for {
+		page, err := spotify.PlaylistTracks(ctx, s.ID, market, offset)
+		if err != nil {
+			return songs, err
+		}
+		if page == nil {
+			return songs, fmt.Errorf("playlist %s not found", s.ID)
+		}
+		songs = append(songs, page.Songs()...)
+		if page.Next == "" || len(page.Items) == 0 {
+			return songs, nil
+		}
+		offset += uint(len(page.Items))
+	}
+}
+
+// fetchAlbum returns all songs of this album source. The first page of songs is part of the album itself, further
+// pages need to be fetched separately.
+func (s Source) fetchAlbum(ctx context.Context, spotify *spotifylib.Client, market string) ([]spotifylib.Song, error) {
+	album, err := spotify.Album(ctx, s.ID, market)
+	if err != nil {
+		return nil, err
+	}
+	if album == nil {
+		return nil, fmt.Errorf("album %s not found", s.ID)
+	}
+
+	songs := album.Songs()
+	page := &album.Tracks
+	for page.Next != "" && len(page.Songs) > 0 {
+		offset := page.Offset + uint(len(page.Songs))
+		page, err = spotify.AlbumTracks(ctx, s.ID, market, offset)
+		if err != nil {
+			return songs, err
+		}
+		if page == nil {
+			return songs, fmt.Errorf("album %s not found", s.ID)
+		}
+		for _, song := range page.Songs {
+			song.Album = album.Album
+			songs = append(songs, song)
+		}
+	}
+	return songs, nil
+}
@@ -0,0 +1,126 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/debugloop/wunschkonzert/pkg/auth"
+	"github.com/debugloop/wunschkonzert/pkg/realtime"
+	"github.com/debugloop/wunschkonzert/pkg/search"
+	spotifylib "github.com/debugloop/wunschkonzert/pkg/spotify"
+)
+
+// TokenCheck checks whether there is a token which has not expired.
+func TokenCheck(oauthService *auth.OAuthService) Check {
+	return Check{
+		Name: "token",
+		Run: func(context.Context) (string, error) {
+			token := oauthService.Token()
+			switch {
+			case token == nil:
+				return "", errors.New("no token, the admin needs to log in")
+			case token.Expiry.IsZero():
+				return "does not expire", nil
+			case !token.Valid():
+				return "", fmt.Errorf("expired at %s", token.Expiry.Format(time.RFC3339))
+			}
+			return fmt.Sprintf("expires in %s", time.Until(token.Expiry).Round(time.Second)), nil
+		},
+	}
+}
+
+// UserLookup shares the user logged in between the checks of a round, so spotify is only asked once. Results are kept
+// for as long as a check may take.
+type UserLookup struct {
+	sync.Mutex
+	spotify *spotifylib.Client
+	user    *spotifylib.User
+	err     error
+	fetched time.Time
+}
+
+// NewUserLookup returns a new UserLookup asking the given client.
+func NewUserLookup(spotify *spotifylib.Client) *UserLookup {
+	return &UserLookup{spotify: spotify}
+}
+
+// User returns the user logged in, asking spotify unless another check has just done so.
+func (l *UserLookup) User(ctx context.Context) (*spotifylib.User, error) {
+	l.Lock()
+	defer l.Unlock()
+	if time.Since(l.fetched) > checkTimeout {
+		l.user, l.err = l.spotify.User(ctx)
+		l.fetched = time.Now()
+	}
+	return l.user, l.err
+}
+
+// SpotifyCheck checks whether spotify is reachable and accepts the token.
+func SpotifyCheck(users *UserLookup) Check {
+	return Check{
+		Name: "spotify",
+		Run: func(ctx context.Context) (string, error) {
+			user, err := users.User(ctx)
+			if err != nil {
+				return "", err
+			}
+			if user == nil {
+				return "", errors.New("no user returned")
+			}
+			return fmt.Sprintf("logged in as %s", user.ID), nil
+		},
+	}
+}
+
+// PlaylistCheck checks whether the playlist guests request songs to can be changed by the user logged in.
+func PlaylistCheck(spotify *spotifylib.Client, users *UserLookup, playlistID string) Check {
+	return Check{
+		Name: "playlist",
+		Run: func(ctx context.Context) (string, error) {
+			user, err := users.User(ctx)
+			if err != nil {
+				return "", err
+			}
+			playlist, err := spotify.PlaylistSummary(ctx, playlistID)
+			if err != nil {
+				return "", err
+			}
+			if user == nil || playlist == nil {
+				return "", errors.New("no playlist returned")
+			}
+			if playlist.Owner.ID != user.ID && !playlist.Collaborative {
+				return "", fmt.Errorf("%q is owned by %s and not collaborative", playlist.Name, playlist.Owner.ID)
+			}
+			return fmt.Sprintf("%q is writable", playlist.Name), nil
+		},
+	}
+}
+
+// CircuitCheck checks whether searches are passed on to spotify, rather than rejected after repeated failures.
+func CircuitCheck(cache *search.Cache) Check {
+	return Check{
+		Name: "search-circuit",
+		Run: func(context.Context) (string, error) {
+			if cache.Unreachable() {
+				return "", errors.New("open after repeated failures, searching locally")
+			}
+			return "closed", nil
+		},
+	}
+}
+
+// PollerCheck checks whether what is playing is kept up to date.
+func PollerCheck(service *realtime.Service) Check {
+	return Check{
+		Name: "realtime-poller",
+		Run: func(context.Context) (string, error) {
+			if err := service.Healthy(); err != nil {
+				return "", err
+			}
+			return "polling as needed", nil
+		},
+	}
+}
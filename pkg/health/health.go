@@ -0,0 +1,113 @@
+// Package health regularly checks whether the app is functional, so it can report readiness to probes and summarize
+// its state for the hosts.
+package health
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// checkTimeout is how long a single check may take before it is considered failed.
+const checkTimeout = 10 * time.Second
+
+// Check is a single aspect of whether the app is functional.
+type Check struct {
+	Name string
+	// Run returns an error if the aspect checked is not functional. It may return details shown on success.
+	Run func(ctx context.Context) (string, error)
+}
+
+// Result is the outcome of the latest run of a Check.
+type Result struct {
+	Name     string
+	Details  string
+	Err      error
+	Checked  time.Time
+	Duration time.Duration
+}
+
+// Checker runs checks in the background, so probes get a fast answer and spotify is not queried for each of them.
+type Checker struct {
+	sync.RWMutex
+	checks   []Check
+	interval time.Duration
+	results  []Result
+}
+
+// NewChecker returns a new Checker which runs all checks in the given interval.
+func NewChecker(interval time.Duration, checks ...Check) *Checker {
+	return &Checker{
+		checks:   checks,
+		interval: interval,
+	}
+}
+
+// Run runs all checks until the context is canceled. It calls ready once all checks have run at least once.
+func (c *Checker) Run(ctx context.Context, ready func()) error {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		results := make([]Result, len(c.checks))
+		var wg sync.WaitGroup
+		for i, check := range c.checks {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				results[i] = run(ctx, check)
+			}()
+		}
+		wg.Wait()
+
+		c.Lock()
+		c.results = results
+		c.Unlock()
+		ready()
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Results returns the latest results of all checks, or nil if they have not run yet.
+func (c *Checker) Results() []Result {
+	c.RLock()
+	defer c.RUnlock()
+	return c.results
+}
+
+// Ready reports whether all checks have passed their latest run.
+func (c *Checker) Ready() bool {
+	results := c.Results()
+	if results == nil {
+		return false
+	}
+	for _, result := range results {
+		if result.Err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// run runs a single check, logging when it fails.
+func run(ctx context.Context, check Check) Result {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	start := time.Now()
+	details, err := check.Run(ctx)
+	if err != nil {
+		slog.WarnContext(ctx, "Health check failed.", "check", check.Name, "error", err)
+	}
+	return Result{
+		Name:     check.Name,
+		Details:  details,
+		Err:      err,
+		Checked:  start,
+		Duration: time.Since(start),
+	}
+}
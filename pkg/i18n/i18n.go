@@ -0,0 +1,226 @@
+// Package i18n provides message catalogs for the ui. The locale of a request is negotiated from a cookie or the
+// Accept-Language header and passed on to all components using the request context.
+package i18n
+
+import (
+	"cmp"
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed locales/*.json
+var locales embed.FS
+
+// CookieName is the name of the cookie overriding the negotiated locale.
+const CookieName = "lang"
+
+type contextKey struct{}
+
+// Locale is a single language's message catalog.
+type Locale struct {
+	// Tag is the BCP 47 language tag of this locale, e.g. "de".
+	Tag      string
+	messages map[string]string
+	catalog  *Catalog
+}
+
+// Catalog holds all available locales.
+type Catalog struct {
+	locales  map[string]*Locale
+	fallback *Locale
+}
+
+// New returns a Catalog with all shipped locales. The default locale is used if negotiation yields no match, and it
+// provides messages missing from other locales.
+func New(defaultTag string) (*Catalog, error) {
+	files, err := locales.ReadDir("locales")
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Catalog{locales: make(map[string]*Locale)}
+	for _, file := range files {
+		raw, err := locales.ReadFile(path.Join("locales", file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		loc := &Locale{
+			Tag:     strings.TrimSuffix(file.Name(), ".json"),
+			catalog: c,
+		}
+		if err := json.Unmarshal(raw, &loc.messages); err != nil {
+			return nil, fmt.Errorf("locale %s: %w", loc.Tag, err)
+		}
+		c.locales[loc.Tag] = loc
+	}
+
+	var ok bool
+	if c.fallback, ok = c.locales[strings.ToLower(defaultTag)]; !ok {
+		return nil, fmt.Errorf("unknown locale %q, available are %s", defaultTag, strings.Join(c.Tags(), ", "))
+	}
+	return c, nil
+}
+
+// Tags returns the tags of all available locales in a stable order.
+func (c *Catalog) Tags() []string {
+	tags := make([]string, 0, len(c.locales))
+	for tag := range c.locales {
+		tags = append(tags, tag)
+	}
+	slices.Sort(tags)
+	return tags
+}
+
+// Lookup returns the locale for a tag, if available. Regional tags are matched by their base language.
+func (c *Catalog) Lookup(tag string) (*Locale, bool) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if loc, ok := c.locales[tag]; ok {
+		return loc, true
+	}
+	base, _, _ := strings.Cut(tag, "-")
+	loc, ok := c.locales[base]
+	return loc, ok
+}
+
+// Negotiate picks the best locale for a request. An explicit choice stored in a cookie is preferred over the
+// Accept-Language header, the default locale is used as a last resort.
+func (c *Catalog) Negotiate(req *http.Request) *Locale {
+	if cookie, err := req.Cookie(CookieName); err == nil {
+		if loc, ok := c.Lookup(cookie.Value); ok {
+			return loc
+		}
+	}
+
+	type preference struct {
+		tag     string
+		quality float64
+	}
+	var preferences []preference
+	for _, part := range strings.Split(req.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if tag == "" || tag == "*" {
+			continue
+		}
+		quality := 1.0
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(q, 64); err == nil {
+				quality = parsed
+			}
+		}
+		preferences = append(preferences, preference{tag, quality})
+	}
+	slices.SortStableFunc(preferences, func(a, b preference) int {
+		return cmp.Compare(b.quality, a.quality)
+	})
+
+	for _, pref := range preferences {
+		if loc, ok := c.Lookup(pref.tag); ok && pref.quality > 0 {
+			return loc
+		}
+	}
+	return c.fallback
+}
+
+// Middleware negotiates the locale of each request and stores it in the request's context.
+func (c *Catalog) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			loc := c.Negotiate(req)
+			w.Header().Add("Vary", "Accept-Language, Cookie")
+			next.ServeHTTP(w, req.WithContext(WithLocale(req.Context(), loc)))
+		},
+	)
+}
+
+// WithLocale returns a context carrying the given locale.
+func WithLocale(ctx context.Context, loc *Locale) context.Context {
+	return context.WithValue(ctx, contextKey{}, loc)
+}
+
+// FromContext returns the locale stored in a context. If there is none, an empty locale is returned which renders
+// message keys as they are.
+func FromContext(ctx context.Context) *Locale {
+	if loc, ok := ctx.Value(contextKey{}).(*Locale); ok {
+		return loc
+	}
+	return &Locale{}
+}
+
+// T translates a message using the locale from the context.
+func T(ctx context.Context, key string, args ...any) string {
+	return FromContext(ctx).T(key, args...)
+}
+
+// T translates a message. Any args are formatted into the message using fmt verbs.
+func (l *Locale) T(key string, args ...any) string {
+	msg, ok := l.messages[key]
+	if !ok && l.catalog != nil {
+		msg, ok = l.catalog.fallback.messages[key]
+	}
+	if !ok {
+		msg = key
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+	return msg
+}
+
+// Name returns the native name of this locale.
+func (l *Locale) Name() string {
+	return l.T("language.name")
+}
+
+// Alternatives returns all other available locales.
+func (l *Locale) Alternatives() []*Locale {
+	if l.catalog == nil {
+		return nil
+	}
+	alternatives := []*Locale{}
+	for _, tag := range l.catalog.Tags() {
+		if tag != l.Tag {
+			alternatives = append(alternatives, l.catalog.locales[tag])
+		}
+	}
+	return alternatives
+}
+
+// List joins items to a list in natural language, e.g. "A, B and C".
+func (l *Locale) List(items []string) string {
+	switch n := len(items); n {
+	case 0:
+		return ""
+	case 1:
+		return items[0]
+	case 2:
+		return l.T("list.pair", items[0], items[1])
+	default:
+		list := l.T("list.end", items[n-2], items[n-1])
+		for i := n - 3; i > 0; i-- {
+			list = l.T("list.middle", items[i], list)
+		}
+		return l.T("list.start", items[0], list)
+	}
+}
+
+// Duration formats a track length or position, e.g. "3:07".
+func (l *Locale) Duration(d time.Duration) string {
+	d = d.Truncate(time.Second)
+	if d >= time.Hour {
+		return fmt.Sprintf("%d:%02d:%02d", int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60)
+	}
+	return fmt.Sprintf("%d:%02d", int(d.Minutes()), int(d.Seconds())%60)
+}
+
+// Clock formats a time of day, e.g. "21:05".
+func (l *Locale) Clock(t time.Time) string {
+	return t.Format(l.T("format.clock"))
+}
@@ -0,0 +1,109 @@
+// Package logging sets up structured logging. Log lines are correlated with the requests and traces they belong to, and
+// secrets as well as personal data of guests are kept out of logs.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// redacted replaces the values of sensitive attributes.
+const redacted = "[redacted]"
+
+// sensitiveKeys are the attribute keys whose values never end up in logs. Guests' names are personal data.
+var sensitiveKeys = map[string]bool{
+	"token":         true,
+	"access_token":  true,
+	"refresh_token": true,
+	"client_secret": true,
+	"secret":        true,
+	"code":          true,
+	"state":         true,
+	"authorization": true,
+	"cookie":        true,
+	"guest":         true,
+}
+
+// New returns a logger writing in the given format, either "text" or "json", at the given level.
+func New(w io.Writer, format string, level slog.Leveler) (*slog.Logger, error) {
+	options := &slog.HandlerOptions{
+		AddSource:   true,
+		Level:       level,
+		ReplaceAttr: redact,
+	}
+	var handler slog.Handler
+	switch format {
+	case "text":
+		handler = slog.NewTextHandler(w, options)
+	case "json":
+		handler = slog.NewJSONHandler(w, options)
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+	return slog.New(&correlatingHandler{Handler: handler}), nil
+}
+
+// ToggleDebug switches between the given level and debug whenever one of the signals is received. It blocks until the
+// context is canceled.
+func ToggleDebug(ctx context.Context, level *slog.LevelVar, base slog.Level, signals ...os.Signal) error {
+	received := make(chan os.Signal, 1)
+	signal.Notify(received, signals...)
+	defer signal.Stop(received)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-received:
+		}
+		if level.Level() == slog.LevelDebug {
+			level.Set(base)
+		} else {
+			level.Set(slog.LevelDebug)
+		}
+		slog.Warn("Changed log level.", "level", level.Level())
+	}
+}
+
+// redact replaces the values of sensitive attributes, regardless of the group they are in.
+func redact(_ []string, attr slog.Attr) slog.Attr {
+	if sensitiveKeys[strings.ToLower(attr.Key)] {
+		return slog.String(attr.Key, redacted)
+	}
+	return attr
+}
+
+// correlatingHandler adds the IDs of the request and the trace a log line belongs to, if any.
+type correlatingHandler struct {
+	slog.Handler
+}
+
+// Handle implements slog.Handler.
+func (h *correlatingHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		record.AddAttrs(
+			slog.String("trace_id", span.TraceID().String()),
+			slog.String("span_id", span.SpanID().String()),
+		)
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+// WithAttrs implements slog.Handler.
+func (h *correlatingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &correlatingHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+// WithGroup implements slog.Handler.
+func (h *correlatingHandler) WithGroup(name string) slog.Handler {
+	return &correlatingHandler{Handler: h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,64 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RequestIDHeader carries the ID of a request, both from proxies in front of us and back to clients.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds IDs given by clients, so they can not flood the logs.
+const maxRequestIDLength = 64
+
+// requestIDKey is the context key under which the ID of the current request is stored.
+type requestIDKey struct{}
+
+// RequestID returns the ID of the request a context belongs to, or an empty string outside of requests.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// RequestIDs is a middleware which identifies each request, so all log lines logged while handling it can be found. An
+// ID passed in by a proxy is kept, otherwise a random one is used. The ID is sent back to clients and added to traces.
+func RequestIDs(next http.Handler) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			id := req.Header.Get(RequestIDHeader)
+			if !validRequestID(id) {
+				id = newRequestID()
+			}
+			w.Header().Set(RequestIDHeader, id)
+			trace.SpanFromContext(req.Context()).SetAttributes(attribute.String("request.id", id))
+			next.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), requestIDKey{}, id)))
+		},
+	)
+}
+
+// validRequestID reports whether an ID given by a client is short and harmless enough to be logged.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID returns a random request ID.
+func newRequestID() string {
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,226 @@
+package realtime
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Bus distributes events to all interested subscribers. The latest event of each name, or of each key for keyed
+// events, is retained and handed to new subscribers right away, so they do not need to wait for the next change.
+// Broadcast events are not retained, as they only matter the moment they happen.
+type Bus struct {
+	sync.Mutex // Guards changes to subscribers and retained events.
+	retained   map[string]Message
+	boot       string
+	sequence   uint64
+	wake       chan struct{}
+	// subscribers is replaced rather than modified, so publishing can use a snapshot without holding the lock.
+	subscribers atomic.Pointer[[]*Subscription]
+
+	activeSubsMetric    metric.Int64UpDownCounter
+	droppedEventsMetric metric.Int64Counter
+	closedSubsMetric    metric.Int64Counter
+}
+
+// NewBus returns a new Bus ready for use.
+func NewBus() *Bus {
+	meter := otel.GetMeterProvider().Meter("github.com/debugloop/wunschkonzert/pkg/realtime")
+	subscriptions, err := meter.Int64UpDownCounter(
+		"realtime.subscription.count",
+		metric.WithDescription("The number of active subscriptions to realtime events."),
+	)
+	if err != nil {
+		slog.Error("Problem setting up otel instrumentation.", "error", err)
+	}
+	dropped, err := meter.Int64Counter(
+		"realtime.update.dropped.count",
+		metric.WithDescription("The number of events replaced by newer ones before a subscriber received them."),
+	)
+	if err != nil {
+		slog.Error("Problem setting up otel instrumentation.", "error", err)
+	}
+	closed, err := meter.Int64Counter(
+		"realtime.subscription.closed.count",
+		metric.WithDescription("The number of subscriptions closed, by whether the subscriber left or was unresponsive."),
+	)
+	if err != nil {
+		slog.Error("Problem setting up otel instrumentation.", "error", err)
+	}
+	b := &Bus{
+		retained:            make(map[string]Message),
+		boot:                strconv.FormatInt(time.Now().UnixNano(), 36),
+		wake:                make(chan struct{}, 1),
+		activeSubsMetric:    subscriptions,
+		droppedEventsMetric: dropped,
+		closedSubsMetric:    closed,
+	}
+	b.subscribers.Store(&[]*Subscription{})
+	return b
+}
+
+// Subscribe returns a new subscription to events of the given names, or to all events if none are given. Retained
+// events are waiting to be received right away, unless the subscriber has received them before. To resume, lastID is
+// the ID of the last message received, and empty otherwise.
+func (b *Bus) Subscribe(ctx context.Context, lastID string, names ...string) *Subscription {
+	b.Lock()
+	defer b.Unlock()
+	sub := newSubscription(names)
+	for _, message := range b.sortedRetained() {
+		if sub.wants(message.Event.Name()) && !b.received(lastID, message) {
+			sub.deliver(message)
+		}
+	}
+	subscribers := append(slices.Clone(*b.subscribers.Load()), sub)
+	b.subscribers.Store(&subscribers)
+	b.activeSubsMetric.Add(ctx, 1)
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+	slog.Debug("Someone just opened the page.", "current-user-count", len(subscribers))
+	return sub
+}
+
+// Unsubscribe ends a subscription. It is safe to call for subscriptions which have been closed already for being
+// unresponsive.
+func (b *Bus) Unsubscribe(ctx context.Context, sub *Subscription) {
+	b.remove(ctx, sub)
+	if sub.close() {
+		b.closedSubsMetric.Add(ctx, 1, metric.WithAttributes(attribute.String("reason", "unsubscribed")))
+	}
+	slog.Debug("Someone just closed the page.", "current-user-count", len(*b.subscribers.Load()))
+}
+
+// Subscribed returns a channel signaling that someone has subscribed. It is meant for a single producer, which can
+// pause while nobody is interested in its events.
+func (b *Bus) Subscribed() <-chan struct{} {
+	return b.wake
+}
+
+// Interested reports whether anyone is subscribed to events of the given name.
+func (b *Bus) Interested(name string) bool {
+	return slices.ContainsFunc(*b.subscribers.Load(), func(sub *Subscription) bool {
+		return sub.wants(name)
+	})
+}
+
+// Forget drops the retained events of the given names, as they have gone stale.
+func (b *Bus) Forget(names ...string) {
+	b.Lock()
+	defer b.Unlock()
+	maps.DeleteFunc(b.retained, func(_ string, message Message) bool {
+		return slices.Contains(names, message.Event.Name())
+	})
+}
+
+// Publish sends an event to all interested subscribers. It replaces any event of the same name or key which has not
+// been received yet, and is retained for new subscribers. It never blocks on subscribers, but closes those which have
+// been unresponsive for a while.
+func (b *Bus) Publish(ctx context.Context, event Event) {
+	b.publish(ctx, event, false)
+}
+
+// Broadcast sends an event to all interested subscribers, like Publish. Subscribers receive each broadcast event
+// though, and new subscribers do not receive any from before they subscribed. It suits events which are transient,
+// like reactions.
+func (b *Bus) Broadcast(ctx context.Context, event Event) {
+	b.publish(ctx, event, true)
+}
+
+func (b *Bus) publish(ctx context.Context, event Event, broadcast bool) {
+	b.Lock()
+	b.sequence++
+	message := Message{
+		ID:        fmt.Sprintf("%s-%d", b.boot, b.sequence),
+		Event:     event,
+		sequence:  b.sequence,
+		key:       eventKey(event),
+		broadcast: broadcast,
+	}
+	if retained, ok := b.retained[message.key]; !broadcast && (!ok || retained.sequence < message.sequence) {
+		b.retained[message.key] = message
+	}
+	b.Unlock()
+
+	// Anyone subscribing from now on has received this event from Subscribe already.
+	var dropped int64
+	unresponsive := []*Subscription{}
+	for _, sub := range *b.subscribers.Load() {
+		if !sub.wants(event.Name()) {
+			continue
+		}
+		if sub.deliver(message) {
+			dropped++
+		}
+		if sub.unresponsive() {
+			unresponsive = append(unresponsive, sub)
+		}
+	}
+	if dropped > 0 {
+		b.droppedEventsMetric.Add(ctx, dropped, metric.WithAttributes(attribute.String("event", event.Name())))
+	}
+	if len(unresponsive) == 0 {
+		return
+	}
+
+	b.remove(ctx, unresponsive...)
+	var closed int64
+	for _, sub := range unresponsive {
+		if sub.close() {
+			closed++
+		}
+	}
+	b.closedSubsMetric.Add(ctx, closed, metric.WithAttributes(attribute.String("reason", "unresponsive")))
+	slog.Warn("Closed unresponsive user streams.", "count", closed, "current-user-count", len(*b.subscribers.Load()))
+}
+
+// sortedRetained returns all retained messages in the order they have been published. The lock must be held.
+func (b *Bus) sortedRetained() []Message {
+	messages := slices.Collect(maps.Values(b.retained))
+	slices.SortFunc(messages, func(a, b Message) int {
+		return cmp.Compare(a.sequence, b.sequence)
+	})
+	return messages
+}
+
+// received reports whether a message has been published before the one with the given ID. IDs from before a restart
+// are not comparable, so everything counts as new then.
+func (b *Bus) received(lastID string, message Message) bool {
+	boot, sequence, ok := strings.Cut(lastID, "-")
+	if !ok || boot != b.boot {
+		return false
+	}
+	last, err := strconv.ParseUint(sequence, 10, 64)
+	return err == nil && message.sequence <= last
+}
+
+// remove drops subscriptions from the subscribers, if they are subscribed.
+func (b *Bus) remove(ctx context.Context, subs ...*Subscription) {
+	removed := make(map[*Subscription]struct{}, len(subs))
+	for _, sub := range subs {
+		removed[sub] = struct{}{}
+	}
+
+	b.Lock()
+	defer b.Unlock()
+	current := *b.subscribers.Load()
+	subscribers := slices.DeleteFunc(slices.Clone(current), func(sub *Subscription) bool {
+		_, ok := removed[sub]
+		return ok
+	})
+	b.subscribers.Store(&subscribers)
+	b.activeSubsMetric.Add(ctx, int64(len(subscribers)-len(current)))
+}
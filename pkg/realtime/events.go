@@ -0,0 +1,112 @@
+package realtime
+
+import (
+	"time"
+
+	"github.com/debugloop/wunschkonzert/pkg/requests"
+	spotifylib "github.com/debugloop/wunschkonzert/pkg/spotify"
+)
+
+// The names of all events carried by the Bus. These are used as SSE event names as well.
+const (
+	EventNowPlaying      = "now-playing"
+	EventQueueChanged    = "queue-changed"
+	EventRequestAdded    = "request-added"
+	EventRequestApproved = "request-approved"
+	EventVoteChanged     = "vote-changed"
+	EventAnnouncement    = "announcement"
+	EventReaction        = "reaction"
+)
+
+// Event is anything published on the Bus.
+type Event interface {
+	// Name identifies the kind of event. Subscribers only ever receive the latest event of each name, unless it is
+	// keyed or broadcast.
+	Name() string
+}
+
+// Keyed is implemented by events which are about one of many things, like a song. Subscribers receive the latest event
+// of each key, rather than of each name, and each key is retained on its own.
+type Keyed interface {
+	Key() string
+}
+
+// Message is an event as published on the Bus.
+type Message struct {
+	// ID uniquely identifies the message. It allows subscribers to resume without receiving anything twice.
+	ID       string
+	Event    Event
+	sequence uint64
+	// key identifies which messages replace each other, which broadcast messages never do.
+	key       string
+	broadcast bool
+}
+
+// eventKey returns the key under which an event replaces earlier ones.
+func eventKey(event Event) string {
+	if keyed, ok := event.(Keyed); ok {
+		return event.Name() + "/" + keyed.Key()
+	}
+	return event.Name()
+}
+
+// NowPlaying is published whenever what is playing changes. The song is nil while nothing is playing.
+type NowPlaying struct {
+	NowPlaying *spotifylib.NowPlaying
+}
+
+// QueueChanged is published whenever the songs coming up next might have changed.
+type QueueChanged struct {
+	Queue *spotifylib.Queue
+}
+
+// RequestAdded is published whenever a guest has requested a song.
+type RequestAdded struct {
+	Request requests.Request
+}
+
+// RequestApproved is published whenever the hosts have approved a request.
+type RequestApproved struct {
+	Request requests.Request
+}
+
+// VoteChanged is published whenever guests have voted on a song.
+type VoteChanged struct {
+	SongURI string
+	Votes   int
+}
+
+// Announcement is published whenever the hosts have something to say to all guests.
+type Announcement struct {
+	Message string
+	At      time.Time
+}
+
+// Reaction is published whenever a guest reacts to what is playing.
+type Reaction struct {
+	Emoji string
+}
+
+// Name implements Event.
+func (NowPlaying) Name() string { return EventNowPlaying }
+
+// Name implements Event.
+func (QueueChanged) Name() string { return EventQueueChanged }
+
+// Name implements Event.
+func (RequestAdded) Name() string { return EventRequestAdded }
+
+// Name implements Event.
+func (RequestApproved) Name() string { return EventRequestApproved }
+
+// Name implements Event.
+func (VoteChanged) Name() string { return EventVoteChanged }
+
+// Key implements Keyed, as the tally of each song is independent of all others.
+func (e VoteChanged) Key() string { return e.SongURI }
+
+// Name implements Event.
+func (Announcement) Name() string { return EventAnnouncement }
+
+// Name implements Event.
+func (Reaction) Name() string { return EventReaction }
@@ -2,102 +2,217 @@ package realtime
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
-	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
 
 	spotifylib "github.com/debugloop/wunschkonzert/pkg/spotify"
 )
 
-// Service is a long running service which regularly queries spotify and provides realtime data to all subscribers. This
-// means all subscribers can share a single realtime data source instead of querying on their own.
+// tracer is used to trace polling spotify.
+var tracer = otel.Tracer("github.com/debugloop/wunschkonzert/pkg/realtime")
+
+const (
+	// endWindow is the time before the predicted end of a song in which spotify is queried at the highest frequency, so
+	// the next song shows up promptly.
+	endWindow = 5 * time.Second
+	// seekTolerance is how far the playback position may deviate from the predicted one before it is considered a seek.
+	seekTolerance = 2 * time.Second
+	// queueRefresh is how often the queue is refreshed if the song has not changed, as guests keep adding songs.
+	queueRefresh = 15 * time.Second
+	// livenessSlack is how much later than planned a poll may happen before the Service is considered stuck.
+	livenessSlack = 30 * time.Second
+	// unhealthyFailures is the number of failed polls in a row after which the Service is considered unhealthy.
+	unhealthyFailures = 3
+)
+
+// Service is a long running service which regularly queries spotify and publishes realtime data on a Bus. This means
+// all subscribers can share a single realtime data source instead of querying on their own. Spotify is queried
+// adaptively: Not at all without subscribers, rarely while nothing is about to change and frequently near the end of a
+// song. Failures are backed off from. Events are only published if something has changed.
 type Service struct {
-	sync.RWMutex
-	o           sync.Once
-	t           time.Ticker
-	subscribers map[chan *spotifylib.NowPlaying]struct{}
+	bus     *Bus
+	fastest time.Duration
+	slowest time.Duration
 
-	spotify          *spotifylib.Client
-	activeSubsMetric metric.Int64UpDownCounter
+	spotify           *spotifylib.Client
+	pollRateMetric    metric.Float64Gauge
+	trackChangeMetric metric.Int64Counter
+
+	// These reflect the state of the poller for health checks.
+	lastPoll atomic.Int64
+	paused   atomic.Bool
+	failures atomic.Int64
 }
 
-// NewService returns a new Service ready for use.
-func NewService(spotify *spotifylib.Client, frequency time.Duration) *Service {
+// NewService returns a new Service ready for use. Spotify is queried no more often than every fastest interval, and at
+// least every slowest interval while anyone is subscribed.
+func NewService(bus *Bus, spotify *spotifylib.Client, fastest time.Duration, slowest time.Duration) *Service {
 	meter := otel.GetMeterProvider().Meter("github.com/debugloop/wunschkonzert/pkg/realtime")
-	subscriptions, err := meter.Int64UpDownCounter(
-		"realtime.subscription.count",
-		metric.WithDescription("The number of active subscriptions to the realtime now playing information."),
+	pollRate, err := meter.Float64Gauge(
+		"realtime.poll.rate",
+		metric.WithDescription("The effective rate at which spotify is queried for now playing information."),
+		metric.WithUnit("1/s"),
+	)
+	if err != nil {
+		slog.Error("Problem setting up otel instrumentation.", "error", err)
+	}
+	trackChanges, err := meter.Int64Counter(
+		"realtime.track.change.count",
+		metric.WithDescription("The number of times the song playing has changed, as noticed while polling."),
 	)
 	if err != nil {
 		slog.Error("Problem setting up otel instrumentation.", "error", err)
 	}
 	return &Service{
-		o:                sync.Once{},
-		t:                *time.NewTicker(frequency),
-		subscribers:      make(map[chan *spotifylib.NowPlaying]struct{}),
-		spotify:          spotify,
-		activeSubsMetric: subscriptions,
+		bus:               bus,
+		fastest:           fastest,
+		slowest:           max(slowest, fastest),
+		spotify:           spotify,
+		pollRateMetric:    pollRate,
+		trackChangeMetric: trackChanges,
 	}
 }
 
-// Start spawns a go routine to run the realtime source.
-func (s *Service) Start(ctx context.Context) {
-	go s.o.Do(func() {
-		s.run(ctx)
-	})
-}
-
-// Subscribe accepts channels which will be fed from the realtime source.
-func (s *Service) Subscribe(ctx context.Context, sub chan *spotifylib.NowPlaying) {
-	s.Lock()
-	defer s.Unlock()
-	s.subscribers[sub] = struct{}{}
-	s.activeSubsMetric.Add(ctx, 1)
-	slog.Debug("Someone just opened the page.", "current-user-count", len(s.subscribers))
-}
-
-// Unsubscribe ends a subscription. This will close the channel.
-func (s *Service) Unsubscribe(ctx context.Context, sub chan *spotifylib.NowPlaying) {
-	s.Lock()
-	defer s.Unlock()
-	delete(s.subscribers, sub)
-	s.activeSubsMetric.Add(ctx, -1)
-	close(sub)
-	slog.Debug("Someone just closed the page.", "current-user-count", len(s.subscribers))
-}
-
-func (s *Service) run(ctx context.Context) {
+// Run queries spotify and publishes realtime data until the context is canceled. Failures to query spotify are retried
+// rather than returned.
+func (s *Service) Run(ctx context.Context) error {
+	var (
+		current     *spotifylib.NowPlaying
+		known       bool
+		paused      bool
+		failures    int
+		lastRefresh time.Time
+	)
+	timer := time.NewTimer(0)
+	defer timer.Stop()
 	for {
 		select {
 		case <-ctx.Done():
-			return
-		case <-s.t.C:
-			np, err := s.spotify.NowPlaying(ctx)
-			if err != nil {
-				slog.Error("Could not retrieve now-playing data.", "error", err)
+			return nil
+		case <-s.bus.Subscribed():
+			if !paused {
 				continue
 			}
-			if np == nil {
-				continue
+			timer.Stop()
+		case <-timer.C:
+		}
+
+		if !s.bus.Interested(EventNowPlaying) && !s.bus.Interested(EventQueueChanged) {
+			slog.Debug("Pausing now playing updates without subscribers.")
+			current, known, paused = nil, false, true
+			s.paused.Store(true)
+			s.bus.Forget(EventNowPlaying, EventQueueChanged)
+			s.pollRateMetric.Record(ctx, 0)
+			continue // Without a reset, the timer stays stopped until someone subscribes.
+		}
+		paused = false
+		s.paused.Store(false)
+
+		// Each poll is traced on its own, as the loop runs for the lifetime of the app.
+		pollCtx, span := tracer.Start(ctx, "realtime.poll", trace.WithNewRoot())
+		np, err := s.spotify.NowPlaying(pollCtx)
+		s.lastPoll.Store(time.Now().UnixNano())
+		var delay time.Duration
+		if err != nil {
+			failures++
+			delay = min(s.fastest<<min(failures, 16), s.slowest)
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "could not retrieve now playing")
+			slog.ErrorContext(pollCtx, "Could not retrieve now-playing data.", "error", err, "retry-in", delay)
+		} else {
+			failures = 0
+			delay = s.nextDelay(np)
+			if !known || changed(current, np) {
+				songChanged := !known || songURI(current) != songURI(np)
+				if known && songChanged && songURI(np) != "" {
+					s.trackChangeMetric.Add(pollCtx, 1)
+				}
+				span.SetAttributes(attribute.Bool("changed", true), attribute.Bool("song.changed", songChanged))
+				current, known = np, true
+				s.bus.Publish(pollCtx, NowPlaying{NowPlaying: np})
+				if songChanged {
+					lastRefresh = time.Time{}
+				}
+			}
+			if s.bus.Interested(EventQueueChanged) && time.Since(lastRefresh) > queueRefresh {
+				lastRefresh = time.Now()
+				s.publishQueue(pollCtx)
 			}
-			s.publish(np)
 		}
+		span.SetAttributes(attribute.Int("failures", failures), attribute.String("next", delay.String()))
+		span.End()
+		s.failures.Store(int64(failures))
+		s.pollRateMetric.Record(ctx, 1/delay.Seconds())
+		timer.Reset(delay)
 	}
 }
 
-func (s *Service) publish(np *spotifylib.NowPlaying) {
-	s.Lock()
-	defer s.Unlock()
-	for sub := range s.subscribers {
-		select {
-		case sub <- np:
-		case <-time.After(200 * time.Millisecond):
-			slog.Warn("Closing unresponsive user stream.", "current-user-count", len(s.subscribers))
-			delete(s.subscribers, sub)
-			close(sub)
-		}
+// Healthy returns an error if spotify has not been queried in time or the latest queries have failed. Pausing without
+// subscribers is healthy.
+func (s *Service) Healthy() error {
+	if s.paused.Load() {
+		return nil
+	}
+	lastPoll := s.lastPoll.Load()
+	if lastPoll == 0 {
+		return errors.New("not polling yet")
+	}
+	if since := time.Since(time.Unix(0, lastPoll)); since > 2*s.slowest+livenessSlack {
+		return fmt.Errorf("last poll %s ago", since.Round(time.Second))
+	}
+	if failures := s.failures.Load(); failures >= unhealthyFailures {
+		return fmt.Errorf("last %d polls failed", failures)
+	}
+	return nil
+}
+
+// publishQueue fetches the queue and publishes it. On errors, subscribers keep the previous one.
+func (s *Service) publishQueue(ctx context.Context) {
+	queue, err := s.spotify.Queue(ctx)
+	if err != nil {
+		slog.WarnContext(ctx, "Problem retrieving queue from spotify.", "error", err)
+		return
+	}
+	if queue != nil {
+		s.bus.Publish(ctx, QueueChanged{Queue: queue})
+	}
+}
+
+// nextDelay predicts when spotify should be queried next. This is shortly before the current song ends, or after the
+// slowest interval if nothing is playing.
+func (s *Service) nextDelay(np *spotifylib.NowPlaying) time.Duration {
+	if np == nil || !np.Playing {
+		return s.slowest
+	}
+	remaining := time.Duration(np.Song.DurationMs)*time.Millisecond - time.Duration(np.ProgressMs)*time.Millisecond
+	return min(max(remaining-endWindow, s.fastest), s.slowest)
+}
+
+// changed reports whether anything but the expected progress of playback differs between two states.
+func changed(previous *spotifylib.NowPlaying, next *spotifylib.NowPlaying) bool {
+	if previous == nil || next == nil {
+		return previous != next
+	}
+	if previous.Song.URI != next.Song.URI || previous.Playing != next.Playing {
+		return true
+	}
+	drift := previous.Progress(next.Received) - next.Progress(next.Received)
+	return drift > seekTolerance || drift < -seekTolerance
+}
+
+// songURI returns the URI of what is playing, if anything.
+func songURI(np *spotifylib.NowPlaying) string {
+	if np == nil {
+		return ""
 	}
+	return np.Song.URI
 }
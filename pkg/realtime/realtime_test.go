@@ -0,0 +1,60 @@
+package realtime
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+
+	spotifylib "github.com/debugloop/wunschkonzert/pkg/spotify"
+)
+
+// BenchmarkPublish measures the fan-out of events to many subscribers, some of which may never receive them.
+func BenchmarkPublish(b *testing.B) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	events := []Event{
+		NowPlaying{NowPlaying: &spotifylib.NowPlaying{Playing: true, Song: spotifylib.Song{URI: "spotify:track:a"}}},
+		NowPlaying{NowPlaying: &spotifylib.NowPlaying{Playing: true, Song: spotifylib.Song{URI: "spotify:track:b"}}},
+	}
+	for _, subscribers := range []int{100, 1000, 10000} {
+		for _, stalled := range []bool{false, true} {
+			b.Run(fmt.Sprintf("subscribers=%d/stalled=%t", subscribers, stalled), func(b *testing.B) {
+				ctx := context.Background()
+				bus := NewBus()
+
+				var wg sync.WaitGroup
+				for i := range subscribers {
+					sub := bus.Subscribe(ctx, "", EventNowPlaying)
+					if stalled && i%2 == 0 {
+						continue // Every other subscriber never receives anything.
+					}
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						for {
+							select {
+							case <-sub.Done():
+								return
+							case <-sub.Ready():
+								sub.Receive()
+							}
+						}
+					}()
+				}
+
+				b.ResetTimer()
+				for i := range b.N {
+					bus.Publish(ctx, events[i%len(events)])
+				}
+				b.StopTimer()
+
+				for _, sub := range *bus.subscribers.Load() {
+					bus.Unsubscribe(ctx, sub)
+				}
+				wg.Wait()
+			})
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package realtime
+
+import (
+	"slices"
+	"sync"
+)
+
+const (
+	// maxMissed is the number of consecutive events a subscriber may miss before it is considered unresponsive and
+	// closed.
+	maxMissed = 10
+	// maxBroadcast is the number of broadcast events waiting in a mailbox, before the oldest are missed.
+	maxBroadcast = 64
+)
+
+// Subscription receives events from the Bus. Its mailbox holds a single event of each name or key only: If the
+// subscriber has not yet received the previous event, it is replaced by the newer one. Broadcast events are kept one by
+// one, up to a limit. This way, slow subscribers never hold up anyone else.
+type Subscription struct {
+	sync.Mutex // Guards the mailbox.
+	names      []string
+	pending    []Message
+	latest     map[string]uint64 // The sequence of the latest message of each key, to recognize outdated ones.
+	ready      chan struct{}
+	done       chan struct{}
+	closed     bool
+	missed     int
+}
+
+func newSubscription(names []string) *Subscription {
+	return &Subscription{
+		names:  names,
+		latest: make(map[string]uint64),
+		ready:  make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+}
+
+// Ready returns a channel signaling that events are waiting to be received.
+func (sub *Subscription) Ready() <-chan struct{} {
+	return sub.ready
+}
+
+// Done returns a channel which is closed when the subscription ends.
+func (sub *Subscription) Done() <-chan struct{} {
+	return sub.done
+}
+
+// Receive takes all waiting events from the mailbox, in the order they were published.
+func (sub *Subscription) Receive() []Message {
+	sub.Lock()
+	defer sub.Unlock()
+	messages := sub.pending
+	sub.pending = nil
+	sub.missed = 0
+	return messages
+}
+
+// wants reports whether this subscription is interested in events of the given name.
+func (sub *Subscription) wants(name string) bool {
+	return len(sub.names) == 0 || slices.Contains(sub.names, name)
+}
+
+// deliver puts an event into the mailbox, replacing any event of the same name or key not yet received. Events are
+// delivered outside of the Bus' lock, so any which are older than one delivered before are outdated and ignored.
+// Broadcast events only replace the oldest broadcast event, once too many are waiting. It returns whether an event has
+// been replaced.
+func (sub *Subscription) deliver(message Message) (replaced bool) {
+	sub.Lock()
+	defer sub.Unlock()
+	if sub.closed {
+		return false
+	}
+	if message.broadcast {
+		broadcasts := 0
+		for _, pending := range sub.pending {
+			if pending.broadcast {
+				broadcasts++
+			}
+		}
+		if broadcasts >= maxBroadcast {
+			oldest := slices.IndexFunc(sub.pending, func(pending Message) bool {
+				return pending.broadcast
+			})
+			sub.pending = slices.Delete(sub.pending, oldest, oldest+1)
+			replaced = true
+		}
+	} else {
+		if message.sequence <= sub.latest[message.key] {
+			return false
+		}
+		sub.latest[message.key] = message.sequence
+		sub.pending = slices.DeleteFunc(sub.pending, func(pending Message) bool {
+			if !pending.broadcast && pending.key == message.key {
+				replaced = true
+				return true
+			}
+			return false
+		})
+	}
+	sub.pending = append(sub.pending, message)
+	if replaced {
+		sub.missed++
+	}
+	select {
+	case sub.ready <- struct{}{}:
+	default:
+	}
+	return replaced
+}
+
+// unresponsive reports whether the subscriber has not received any of the last few events.
+func (sub *Subscription) unresponsive() bool {
+	sub.Lock()
+	defer sub.Unlock()
+	return sub.missed >= maxMissed
+}
+
+// close ends the subscription. It returns whether it has been closed by this call, so it is safe to call repeatedly.
+func (sub *Subscription) close() bool {
+	sub.Lock()
+	defer sub.Unlock()
+	if sub.closed {
+		return false
+	}
+	sub.closed = true
+	sub.pending = nil
+	close(sub.done)
+	return true
+}
@@ -0,0 +1,159 @@
+// Package requests keeps track of the songs guests have requested.
+package requests
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	spotifylib "github.com/debugloop/wunschkonzert/pkg/spotify"
+)
+
+// Request is a single song requested by a guest.
+type Request struct {
+	Song  spotifylib.Song `json:"song"`
+	Guest string          `json:"guest,omitempty"`
+	At    time.Time       `json:"at"`
+}
+
+// History holds all requests made. It optionally persists them to disk, one JSON object per line, so they survive
+// restarts. The file contains the names guests have given and is never pruned, so it should be removed after an event.
+type History struct {
+	sync.RWMutex
+	requests    []Request
+	persistPath string
+}
+
+// NewHistory returns a new History. If persistPath is not empty, previous requests are restored from it.
+func NewHistory(persistPath string) *History {
+	h := &History{
+		persistPath: persistPath,
+	}
+	if persistPath != "" {
+		h.restore()
+	}
+	return h
+}
+
+// Add records a request.
+func (h *History) Add(r Request) {
+	h.Lock()
+	defer h.Unlock()
+	h.requests = append(h.requests, r)
+
+	if h.persistPath == "" {
+		return
+	}
+
+	line, err := json.Marshal(r)
+	if err != nil {
+		slog.Error("Could not persist request.", "error", err)
+		return
+	}
+
+	f, err := os.OpenFile(h.persistPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		slog.Error("Opening of request history failed.", "error", err)
+		return
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			slog.Error("Closing of request history failed.", "error", err)
+		}
+	}()
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		slog.Error("Writing of request history failed.", "error", err)
+	}
+}
+
+// Since returns all requests made after the given time, oldest first.
+func (h *History) Since(t time.Time) []Request {
+	h.RLock()
+	defer h.RUnlock()
+	for i, r := range h.requests {
+		if r.At.After(t) {
+			return append([]Request(nil), h.requests[i:]...)
+		}
+	}
+	return nil
+}
+
+// Trending returns up to limit songs requested after the given time, the most often requested first. Ties are broken by
+// which song was requested most recently.
+func (h *History) Trending(since time.Time, limit int) []spotifylib.Song {
+	recent := h.Since(since)
+	counts := make(map[string]int)
+	songs := []spotifylib.Song{}
+	for i := len(recent) - 1; i >= 0; i-- {
+		song := recent[i].Song
+		if counts[song.URI] == 0 && song.Name != "" {
+			songs = append(songs, song)
+		}
+		counts[song.URI]++
+	}
+	slices.SortStableFunc(songs, func(a, b spotifylib.Song) int {
+		return cmp.Compare(counts[b.URI], counts[a.URI])
+	})
+	return songs[:min(limit, len(songs))]
+}
+
+// Requester returns the guest who most recently requested a song.
+func (h *History) Requester(songURI string) (string, bool) {
+	h.RLock()
+	defer h.RUnlock()
+	for i := len(h.requests) - 1; i >= 0; i-- {
+		if h.requests[i].Song.URI == songURI {
+			return h.requests[i].Guest, h.requests[i].Guest != ""
+		}
+	}
+	return "", false
+}
+
+// Requested reports whether a song has been requested before.
+func (h *History) Requested(songURI string) bool {
+	h.RLock()
+	defer h.RUnlock()
+	for _, request := range h.requests {
+		if request.Song.URI == songURI {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *History) restore() {
+	f, err := os.Open(h.persistPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		slog.Warn("Reading of request history failed.", "error", err)
+		return
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r Request
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			slog.Warn("Skipping unreadable request in history.", "error", err)
+			continue
+		}
+		h.requests = append(h.requests, r)
+	}
+	if err := scanner.Err(); err != nil {
+		slog.Warn("Reading of request history failed.", "error", err)
+	}
+
+	slog.Info("Restored request history.", "count", len(h.requests))
+}
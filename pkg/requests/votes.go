@@ -0,0 +1,44 @@
+package requests
+
+import "sync"
+
+// Votes tallies the votes of guests on songs. Each voter counts once per song.
+type Votes struct {
+	sync.Mutex
+	votes map[string]map[string]int
+}
+
+// NewVotes returns a new Votes without any votes cast.
+func NewVotes() *Votes {
+	return &Votes{
+		votes: make(map[string]map[string]int),
+	}
+}
+
+// Cast records a voter's vote on a song, replacing any previous vote of theirs, and hands the song's new tally to
+// announce. Values are clamped to a single up or down vote, with zero withdrawing the vote. Announcing happens while the
+// lock is held, so tallies are announced in the order votes have been cast and the latest announced one is current.
+func (v *Votes) Cast(songURI string, voter string, value int, announce func(votes int)) {
+	v.Lock()
+	defer v.Unlock()
+	voters, ok := v.votes[songURI]
+	if !ok {
+		voters = make(map[string]int)
+		v.votes[songURI] = voters
+	}
+	if value = min(max(value, -1), 1); value == 0 {
+		delete(voters, voter)
+	} else {
+		voters[voter] = value
+	}
+	announce(v.tally(songURI))
+}
+
+// tally returns the sum of all votes on a song. The lock must be held.
+func (v *Votes) tally(songURI string) int {
+	sum := 0
+	for _, value := range v.votes[songURI] {
+		sum += value
+	}
+	return sum
+}
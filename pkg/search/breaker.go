@@ -0,0 +1,75 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	spotifylib "github.com/debugloop/wunschkonzert/pkg/spotify"
+)
+
+// ErrCircuitOpen is returned instead of asking spotify while it is considered unreachable.
+var ErrCircuitOpen = errors.New("circuit open, spotify is considered unreachable")
+
+// breaker stops asking spotify after a number of consecutive failures. After a cooldown, a single search is let through
+// as a probe, and all others are rejected until it succeeds.
+type breaker struct {
+	sync.Mutex
+	failures  int
+	threshold int
+	cooldown  time.Duration
+	openUntil time.Time
+	probing   bool
+}
+
+// allow reports whether spotify may be asked right now.
+func (b *breaker) allow() bool {
+	b.Lock()
+	defer b.Unlock()
+	switch {
+	case time.Now().Before(b.openUntil):
+		return false
+	case b.failures < b.threshold:
+		return true
+	case b.probing:
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// open reports whether spotify is considered unreachable, which it is until a probe succeeded.
+func (b *breaker) open() bool {
+	b.Lock()
+	defer b.Unlock()
+	return b.failures >= b.threshold
+}
+
+// record takes note of the outcome of asking spotify. Canceled searches say nothing about spotify and are ignored.
+func (b *breaker) record(err error) {
+	b.Lock()
+	defer b.Unlock()
+	b.probing = false
+	switch {
+	case errors.Is(err, context.Canceled):
+	case !failure(err):
+		b.failures = 0
+	default:
+		b.failures++
+		if b.failures >= b.threshold {
+			b.openUntil = time.Now().Add(b.cooldown)
+		}
+	}
+}
+
+// failure reports whether an error means spotify is unreachable or overloaded. Other client errors, like a malformed
+// query, mean it is reachable just fine.
+func failure(err error) bool {
+	var statusErr *spotifylib.StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Code >= http.StatusInternalServerError || statusErr.Code == http.StatusTooManyRequests
+	}
+	return err != nil
+}
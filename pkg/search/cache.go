@@ -0,0 +1,176 @@
+// Package search sits between the search handlers and spotify. It keeps spotify from being asked the same thing over
+// and over again while a room full of guests types.
+package search
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/singleflight"
+
+	spotifylib "github.com/debugloop/wunschkonzert/pkg/spotify"
+)
+
+const (
+	// searchTimeout bounds searches shared by multiple guests, as these must not be canceled when the first guest
+	// leaves. It is kept short, as guests are better served by the local catalog than by waiting.
+	searchTimeout = 5 * time.Second
+	// breakerThreshold is the number of consecutive failed searches after which spotify is considered unreachable.
+	breakerThreshold = 3
+	// breakerCooldown is how long spotify is not asked after it has been considered unreachable.
+	breakerCooldown = 30 * time.Second
+)
+
+// tracer is used to trace searches, including whether they were answered from cache.
+var tracer = otel.Tracer("github.com/debugloop/wunschkonzert/pkg/search")
+
+// Cache is a LRU cache of search results, which expire after a while. Identical searches in flight at the same time are
+// coalesced into a single request to spotify. After repeated failures, spotify is not asked for a while.
+type Cache struct {
+	sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+	size    int
+	ttl     time.Duration
+	group   singleflight.Group
+	breaker *breaker
+
+	spotify       *spotifylib.Client
+	lookupsMetric metric.Int64Counter
+}
+
+type entry struct {
+	key     string
+	result  *spotifylib.SearchResult
+	expires time.Time
+}
+
+// NewCache returns a new Cache holding up to size results for the given duration.
+func NewCache(spotify *spotifylib.Client, size int, ttl time.Duration) *Cache {
+	meter := otel.GetMeterProvider().Meter("github.com/debugloop/wunschkonzert/pkg/search")
+	lookups, err := meter.Int64Counter(
+		"search.cache.lookup.count",
+		metric.WithDescription("The number of searches, by whether they were answered from cache, coalesced, sent to spotify or rejected."),
+	)
+	if err != nil {
+		slog.Error("Problem setting up otel instrumentation.", "error", err)
+	}
+	return &Cache{
+		entries:       make(map[string]*list.Element),
+		order:         list.New(),
+		size:          size,
+		ttl:           ttl,
+		breaker:       &breaker{threshold: breakerThreshold, cooldown: breakerCooldown},
+		spotify:       spotify,
+		lookupsMetric: lookups,
+	}
+}
+
+// Search works like spotify.Client.Search, but answers from cache if possible. Queries differing only in case or
+// whitespace share their results.
+func (c *Cache) Search(ctx context.Context, query spotifylib.Query, market string, limit uint, offset uint, types ...spotifylib.SearchType) (*spotifylib.SearchResult, error) {
+	ctx, span := tracer.Start(ctx, "search.Cache.Search", trace.WithAttributes(
+		attribute.String("query", query.String()),
+		attribute.Int("offset", int(offset)),
+	))
+	defer span.End()
+
+	key := cacheKey(query, market, limit, offset, types)
+	if result, ok := c.get(key); ok {
+		c.lookupsMetric.Add(ctx, 1, metric.WithAttributes(attribute.String("result", "hit")))
+		span.SetAttributes(attribute.String("result", "hit"))
+		return result, nil
+	}
+
+	if !c.breaker.allow() {
+		c.lookupsMetric.Add(ctx, 1, metric.WithAttributes(attribute.String("result", "rejected")))
+		span.SetAttributes(attribute.String("result", "rejected"))
+		span.SetStatus(codes.Error, ErrCircuitOpen.Error())
+		return nil, ErrCircuitOpen
+	}
+
+	// Only the caller whose function runs asks spotify, all others joining in are coalesced.
+	asked := false
+	value, err, _ := c.group.Do(key, func() (any, error) {
+		asked = true
+		c.lookupsMetric.Add(ctx, 1, metric.WithAttributes(attribute.String("result", "miss")))
+		span.SetAttributes(attribute.String("result", "miss"))
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), searchTimeout)
+		defer cancel()
+		result, err := c.spotify.Search(ctx, query, market, limit, offset, types...)
+		c.breaker.record(err)
+		if err != nil {
+			return nil, err
+		}
+		if result == nil {
+			// Spotify had no content, which is as good as no results.
+			result = &spotifylib.SearchResult{}
+		}
+		c.put(key, result)
+		return result, nil
+	})
+	if !asked {
+		c.lookupsMetric.Add(ctx, 1, metric.WithAttributes(attribute.String("result", "coalesced")))
+		span.SetAttributes(attribute.String("result", "coalesced"))
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "search failed")
+		return nil, err
+	}
+	return value.(*spotifylib.SearchResult), nil
+}
+
+// Unreachable reports whether spotify is currently considered unreachable, in which case searches fail immediately.
+func (c *Cache) Unreachable() bool {
+	return c.breaker.open()
+}
+
+func (c *Cache) get(key string) (*spotifylib.SearchResult, bool) {
+	c.Lock()
+	defer c.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := elem.Value.(*entry)
+	if time.Now().After(e.expires) {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return e.result, true
+}
+
+func (c *Cache) put(key string, result *spotifylib.SearchResult) {
+	c.Lock()
+	defer c.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = &entry{key: key, result: result, expires: time.Now().Add(c.ttl)}
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&entry{key: key, result: result, expires: time.Now().Add(c.ttl)})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*entry).key)
+	}
+}
+
+// cacheKey normalizes a search, so that trivially differing queries share a cache entry.
+func cacheKey(query spotifylib.Query, market string, limit uint, offset uint, types []spotifylib.SearchType) string {
+	normalized := strings.Join(strings.Fields(strings.ToLower(query.String())), " ")
+	return fmt.Sprintf("%s|%s|%d|%d|%v", normalized, strings.ToUpper(market), limit, offset, types)
+}
@@ -8,10 +8,14 @@ import (
 	"log/slog"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
 
 	"github.com/debugloop/wunschkonzert/pkg/auth"
 )
@@ -21,14 +25,38 @@ type Client struct {
 	*http.Client
 	oauthService *auth.OAuthService
 	base         string
+
+	requestDurationMetric metric.Float64Histogram
+}
+
+// StatusError is returned when spotify responds with an unexpected status.
+type StatusError struct {
+	Code   int
+	Status string
+}
+
+// Error implements error.
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("received %s", e.Status)
 }
 
 // New returns a new spotifyt client. The parameter should contain a self-authenticating RoundTripper.
 func New(oauthService *auth.OAuthService) *Client {
+	meter := otel.GetMeterProvider().Meter("github.com/debugloop/wunschkonzert/pkg/spotify")
+	requestDuration, err := meter.Float64Histogram(
+		"spotify.request.duration",
+		metric.WithDescription("The time taken by requests to spotify, by endpoint and status."),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10),
+	)
+	if err != nil {
+		slog.Error("Problem setting up otel instrumentation.", "error", err)
+	}
 	newClient := &Client{
-		Client:       &http.Client{},
-		oauthService: oauthService,
-		base:         "https://api.spotify.com/v1",
+		Client:                &http.Client{},
+		oauthService:          oauthService,
+		base:                  "https://api.spotify.com/v1",
+		requestDurationMetric: requestDuration,
 	}
 	newClient.refreshTransport()
 	return newClient
@@ -49,24 +77,34 @@ func (c *Client) refreshTransport() {
 	c.Transport = instrumentedTransport
 }
 
-func get[T any](c *Client, ctx context.Context, path string, params ...map[string]string) (*T, error) {
-	pairedParams := []string{}
-	for _, paramSet := range params {
-		for k, v := range paramSet {
-			pairedParams = append(pairedParams, fmt.Sprintf("%s=%s", k, v))
-		}
+// do sends a request and records its duration. The endpoint names the path without any IDs, so it is fit to be a metric
+// attribute.
+func (c *Client) do(req *http.Request, endpoint string) (*http.Response, error) {
+	start := time.Now()
+	resp, err := c.Do(req)
+	status := "error"
+	if err == nil {
+		status = strconv.Itoa(resp.StatusCode)
 	}
-	appendableParams := strings.Join(pairedParams, "&")
-	if appendableParams != "" {
-		appendableParams = "?" + appendableParams
+	c.requestDurationMetric.Record(req.Context(), time.Since(start).Seconds(), metric.WithAttributes(
+		attribute.String("endpoint", endpoint),
+		attribute.String("method", req.Method),
+		attribute.String("status", status),
+	))
+	return resp, err
+}
+
+func get[T any](c *Client, ctx context.Context, endpoint string, path string, params url.Values) (*T, error) {
+	if len(params) > 0 {
+		path += "?" + params.Encode()
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "GET", c.base+path+appendableParams, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", c.base+path, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	resp, err := c.Do(req)
+	resp, err := c.do(req, endpoint)
 	if err != nil {
 		c.refreshTransport()
 		return nil, err
@@ -80,7 +118,7 @@ func get[T any](c *Client, ctx context.Context, path string, params ...map[strin
 
 	switch resp.StatusCode {
 	default:
-		return nil, fmt.Errorf("received %s", resp.Status)
+		return nil, &StatusError{Code: resp.StatusCode, Status: resp.Status}
 	case 204:
 		return nil, nil
 	case 200:
@@ -98,7 +136,7 @@ func get[T any](c *Client, ctx context.Context, path string, params ...map[strin
 	return result, nil
 }
 
-func post[T any](c *Client, ctx context.Context, path string, payload *T) error {
+func post[T any](c *Client, ctx context.Context, endpoint string, path string, payload *T) error {
 	reader := new(bytes.Buffer)
 	err := json.NewEncoder(reader).Encode(payload)
 	if err != nil {
@@ -112,7 +150,7 @@ func post[T any](c *Client, ctx context.Context, path string, payload *T) error
 
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := c.Do(req)
+	resp, err := c.do(req, endpoint)
 	if err != nil {
 		c.refreshTransport()
 		return err
@@ -120,7 +158,7 @@ func post[T any](c *Client, ctx context.Context, path string, payload *T) error
 
 	switch code := resp.StatusCode; {
 	default:
-		return fmt.Errorf("received %s", resp.Status)
+		return &StatusError{Code: resp.StatusCode, Status: resp.Status}
 	case code >= 200 && code < 300:
 		return nil
 	}
@@ -129,21 +167,99 @@ func post[T any](c *Client, ctx context.Context, path string, payload *T) error
 // NowPlaying returns the currently playing song. You should rather use the realtime.Service to receive this
 // information.
 func (c *Client) NowPlaying(ctx context.Context) (*NowPlaying, error) {
-	return get[NowPlaying](c, ctx, "/me/player/currently-playing")
+	np, err := get[NowPlaying](c, ctx, "/me/player/currently-playing", "/me/player/currently-playing", nil)
+	if np != nil {
+		np.Received = time.Now()
+	}
+	return np, err
+}
+
+// Queue returns the currently playing song and the songs queued after it.
+func (c *Client) Queue(ctx context.Context) (*Queue, error) {
+	return get[Queue](c, ctx, "/me/player/queue", "/me/player/queue", nil)
+}
+
+// Track returns a single song by its ID.
+func (c *Client) Track(ctx context.Context, id string, market string) (*Song, error) {
+	return get[Song](c, ctx, "/tracks/{id}", "/tracks/"+url.PathEscape(id), url.Values{
+		"market": {market},
+	})
 }
 
 // User returns information about the currently authenticated user.
 func (c *Client) User(ctx context.Context) (*User, error) {
-	return get[User](c, ctx, "/me")
+	return get[User](c, ctx, "/me", "/me", nil)
+}
+
+// SearchType is a kind of item that can be searched for.
+type SearchType string
+
+// All search types used by this app.
+const (
+	SearchTrack    SearchType = "track"
+	SearchArtist   SearchType = "artist"
+	SearchAlbum    SearchType = "album"
+	SearchPlaylist SearchType = "playlist"
+)
+
+// Search executes a search and returns the results. The limit and offset apply to each of the types searched for.
+func (c *Client) Search(ctx context.Context, query Query, market string, limit uint, offset uint, types ...SearchType) (*SearchResult, error) {
+	typeNames := make([]string, len(types))
+	for i, t := range types {
+		typeNames[i] = string(t)
+	}
+	return get[SearchResult](c, ctx, "/search", "/search", url.Values{
+		"q":      {query.String()},
+		"type":   {strings.Join(typeNames, ",")},
+		"market": {market},
+		"limit":  {strconv.FormatUint(uint64(limit), 10)},
+		"offset": {strconv.FormatUint(uint64(offset), 10)},
+	})
+}
+
+// ArtistTopTracks returns the most popular songs of an artist.
+func (c *Client) ArtistTopTracks(ctx context.Context, artistID string, market string) (*TopTracks, error) {
+	return get[TopTracks](c, ctx, "/artists/{id}/top-tracks", "/artists/"+url.PathEscape(artistID)+"/top-tracks", url.Values{
+		"market": {market},
+	})
+}
+
+// Album returns an album including its first songs.
+func (c *Client) Album(ctx context.Context, albumID string, market string) (*FullAlbum, error) {
+	return get[FullAlbum](c, ctx, "/albums/{id}", "/albums/"+url.PathEscape(albumID), url.Values{
+		"market": {market},
+	})
+}
+
+// AlbumTracks returns a page of songs of an album, up to 50 at a time.
+func (c *Client) AlbumTracks(ctx context.Context, albumID string, market string, offset uint) (*AlbumTracks, error) {
+	return get[AlbumTracks](c, ctx, "/albums/{id}/tracks", "/albums/"+url.PathEscape(albumID)+"/tracks", url.Values{
+		"market": {market},
+		"limit":  {"50"},
+		"offset": {strconv.FormatUint(uint64(offset), 10)},
+	})
+}
+
+// Playlist returns a playlist including its first songs.
+func (c *Client) Playlist(ctx context.Context, playlistID string, market string) (*FullPlaylist, error) {
+	return get[FullPlaylist](c, ctx, "/playlists/{id}", "/playlists/"+url.PathEscape(playlistID), url.Values{
+		"market": {market},
+	})
+}
+
+// PlaylistSummary returns the name and owner of a playlist and whether it is collaborative, without any of its songs.
+func (c *Client) PlaylistSummary(ctx context.Context, playlistID string) (*Playlist, error) {
+	return get[Playlist](c, ctx, "/playlists/{id}", "/playlists/"+url.PathEscape(playlistID), url.Values{
+		"fields": {"id,name,owner.id,collaborative"},
+	})
 }
 
-// Search executes a search and returns the results.
-func (c *Client) Search(ctx context.Context, query string, market string, limit uint) (*SearchResult, error) {
-	return get[SearchResult](c, ctx, "/search", map[string]string{
-		"q":      url.QueryEscape(query),
-		"type":   "track",
-		"market": market,
-		"limit":  fmt.Sprintf("%d", limit),
+// PlaylistTracks returns a page of songs of a playlist, up to 100 at a time.
+func (c *Client) PlaylistTracks(ctx context.Context, playlistID string, market string, offset uint) (*PlaylistTracks, error) {
+	return get[PlaylistTracks](c, ctx, "/playlists/{id}/tracks", "/playlists/"+url.PathEscape(playlistID)+"/tracks", url.Values{
+		"market": {market},
+		"limit":  {"100"},
+		"offset": {strconv.FormatUint(uint64(offset), 10)},
 	})
 }
 
@@ -153,5 +269,5 @@ func (c *Client) AddToPlaylist(ctx context.Context, playlistID string, songUri s
 		Uris:     []string{songUri},
 		Position: 0,
 	}
-	return post(c, ctx, fmt.Sprintf("/playlists/%s/tracks", playlistID), req)
+	return post(c, ctx, "/playlists/{id}/tracks", fmt.Sprintf("/playlists/%s/tracks", playlistID), req)
 }
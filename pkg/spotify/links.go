@@ -0,0 +1,43 @@
+package spotify
+
+import (
+	"net/url"
+	"strings"
+)
+
+// ParseLink detects links to open.spotify.com and spotify URIs as shared from the Spotify apps. It returns the type and
+// ID of the linked item, ok is false if s is no such link.
+func ParseLink(s string) (kind SearchType, id string, ok bool) {
+	s = strings.TrimSpace(s)
+	var parts []string
+	if rest, found := strings.CutPrefix(s, "spotify:"); found {
+		parts = strings.Split(rest, ":")
+	} else {
+		if !strings.Contains(s, "://") {
+			s = "https://" + s
+		}
+		u, err := url.Parse(s)
+		if err != nil || u.Hostname() != "open.spotify.com" {
+			return "", "", false
+		}
+		parts = strings.Split(strings.Trim(u.Path, "/"), "/")
+		// Links may be localized or point to an embed, e.g. /intl-de/track/... or /embed/track/...
+		if len(parts) > 0 && (strings.HasPrefix(parts[0], "intl-") || parts[0] == "embed") {
+			parts = parts[1:]
+		}
+	}
+	// Legacy playlist links include the owner, e.g. spotify:user:someone:playlist:...
+	if len(parts) == 4 && parts[0] == "user" {
+		parts = parts[2:]
+	}
+	if len(parts) != 2 || parts[1] == "" {
+		return "", "", false
+	}
+
+	switch kind := SearchType(parts[0]); kind {
+	case SearchTrack, SearchAlbum, SearchPlaylist, SearchArtist:
+		return kind, parts[1], true
+	default:
+		return "", "", false
+	}
+}
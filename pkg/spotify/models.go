@@ -1,21 +1,88 @@
 package spotify
 
+import "time"
+
 // AddTracksToPlaylistReq encodes a request to Spotify.
 type AddTracksToPlaylistReq struct {
 	Uris     []string `json:"uris"`
 	Position uint     `json:"position"`
 }
 
-// SearchResult encodes a response from Spotify.
+// SearchResult encodes a response from Spotify. Only the types searched for are populated.
 type SearchResult struct {
-	Tracks SearchResultTracks `json:"tracks"`
+	Tracks    SearchResultTracks    `json:"tracks"`
+	Artists   SearchResultArtists   `json:"artists"`
+	Albums    SearchResultAlbums    `json:"albums"`
+	Playlists SearchResultPlaylists `json:"playlists"`
+}
+
+// Paging encodes the pagination info included in various responses from Spotify.
+type Paging struct {
+	Next   string `json:"next"`
+	Offset uint   `json:"offset"`
+	Limit  uint   `json:"limit"`
+	Total  uint   `json:"total"`
 }
 
 // SearchResultTracks encodes a subset of a response from Spotify.
 type SearchResultTracks struct {
+	Paging
 	Songs []Song `json:"items"`
 }
 
+// SearchResultArtists encodes a subset of a response from Spotify.
+type SearchResultArtists struct {
+	Paging
+	Artists []Artist `json:"items"`
+}
+
+// SearchResultAlbums encodes a subset of a response from Spotify.
+type SearchResultAlbums struct {
+	Paging
+	Albums []Album `json:"items"`
+}
+
+// SearchResultPlaylists encodes a subset of a response from Spotify. Items may be null.
+type SearchResultPlaylists struct {
+	Paging
+	Playlists []*Playlist `json:"items"`
+}
+
+// TopTracks encodes a response from Spotify.
+type TopTracks struct {
+	Songs []Song `json:"tracks"`
+}
+
+// FullAlbum encodes a response from Spotify. The songs therein do not contain the album.
+type FullAlbum struct {
+	Album
+	Tracks AlbumTracks `json:"tracks"`
+}
+
+// AlbumTracks encodes a page of an album's songs, as included in or continuing a FullAlbum. The songs therein do not
+// contain the album.
+type AlbumTracks struct {
+	Paging
+	Songs []Song `json:"items"`
+}
+
+// FullPlaylist encodes a response from Spotify.
+type FullPlaylist struct {
+	Playlist
+	Tracks PlaylistTracks `json:"tracks"`
+}
+
+// PlaylistTracks encodes a page of a playlist's songs, as included in or continuing a FullPlaylist.
+type PlaylistTracks struct {
+	Paging
+	Items []PlaylistItem `json:"items"`
+}
+
+// PlaylistItem encodes a subset of a response from Spotify. The song is nil if it is no longer available.
+type PlaylistItem struct {
+	Song *Song `json:"track"`
+}
+
 // NowPlaying encodes a response from Spotify.
 type NowPlaying struct {
 	LastChange uint   `json:"timestamp"`
@@ -23,6 +90,8 @@ type NowPlaying struct {
 	Type       string `json:"currently_playing_type"`
 	Playing    bool   `json:"is_playing"`
 	Song       Song   `json:"item"`
+	// Received is when this was received from Spotify, which is when ProgressMs was accurate.
+	Received time.Time `json:"-"`
 }
 
 // Song encodes a subset of various responses from Spotify.
@@ -34,14 +103,28 @@ type Song struct {
 	Album      Album    `json:"album"`
 	Artists    []Artist `json:"artists"`
 	DurationMs uint     `json:"duration_ms"`
+	Explicit   bool     `json:"explicit"`
+	Popularity uint     `json:"popularity"`
+	PreviewURL string   `json:"preview_url"`
 }
 
 // Artist encodes a subset of various responses from Spotify.
 type Artist struct {
-	ID   string `json:"id"`
-	Name string `json:"name"`
-	Type string `json:"type"`
-	URI  string `json:"uri"`
+	ID     string      `json:"id"`
+	Name   string      `json:"name"`
+	Type   string      `json:"type"`
+	URI    string      `json:"uri"`
+	Images CoverImages `json:"images"`
+}
+
+// Playlist encodes a subset of various responses from Spotify.
+type Playlist struct {
+	ID            string      `json:"id"`
+	Name          string      `json:"name"`
+	URI           string      `json:"uri"`
+	Images        CoverImages `json:"images"`
+	Owner         User        `json:"owner"`
+	Collaborative bool        `json:"collaborative"`
 }
 
 // CoverImage encodes a subset of a response from Spotify.
@@ -51,16 +134,25 @@ type CoverImage struct {
 	URL    string `json:"url"`
 }
 
+// CoverImages encodes the different sizes of an image Spotify offers.
+type CoverImages []CoverImage
+
 // Album encodes a subset of a response from Spotify.
 type Album struct {
-	ID                   string       `json:"id"`
-	Name                 string       `json:"name"`
-	Type                 string       `json:"album_type"`
-	URI                  string       `json:"uri"`
-	Artists              []Artist     `json:"artists"`
-	CoverImages          []CoverImage `json:"images"`
-	ReleaseDate          string       `json:"release_date"`
-	ReleaseDatePrecision string       `json:"release_date_precision"`
+	ID                   string      `json:"id"`
+	Name                 string      `json:"name"`
+	Type                 string      `json:"album_type"`
+	URI                  string      `json:"uri"`
+	Artists              []Artist    `json:"artists"`
+	CoverImages          CoverImages `json:"images"`
+	ReleaseDate          string      `json:"release_date"`
+	ReleaseDatePrecision string      `json:"release_date_precision"`
+}
+
+// Queue encodes a response from Spotify.
+type Queue struct {
+	CurrentlyPlaying *Song  `json:"currently_playing"`
+	Songs            []Song `json:"queue"`
 }
 
 // User encodes a subset of a response from Spotify.
@@ -71,3 +163,56 @@ type User struct {
 	Type  string `json:"type"`
 	URI   string `json:"uri"`
 }
+
+// Best returns the smallest image which is at least as wide as requested, or the largest one if none is. It returns nil
+// if there are no images.
+func (images CoverImages) Best(width uint) *CoverImage {
+	var best *CoverImage
+	for i := range images {
+		img := &images[i]
+		switch {
+		case best == nil:
+			best = img
+		case best.Width < width && img.Width > best.Width:
+			best = img
+		case img.Width >= width && img.Width < best.Width:
+			best = img
+		}
+	}
+	return best
+}
+
+// Progress predicts the playback position at the given time, assuming playback has continued since it was received.
+func (np *NowPlaying) Progress(at time.Time) time.Duration {
+	progress := time.Duration(np.ProgressMs) * time.Millisecond
+	if np.Playing && !np.Received.IsZero() {
+		progress += at.Sub(np.Received)
+	}
+	return min(max(progress, 0), time.Duration(np.Song.DurationMs)*time.Millisecond)
+}
+
+// Songs returns the album's songs, each including the album.
+func (a *FullAlbum) Songs() []Song {
+	songs := make([]Song, len(a.Tracks.Songs))
+	for i, song := range a.Tracks.Songs {
+		song.Album = a.Album
+		songs[i] = song
+	}
+	return songs
+}
+
+// Songs returns the playlist's songs, skipping any that are no longer available.
+func (p *FullPlaylist) Songs() []Song {
+	return p.Tracks.Songs()
+}
+
+// Songs returns the songs on this page, skipping any that are no longer available.
+func (p *PlaylistTracks) Songs() []Song {
+	songs := make([]Song, 0, len(p.Items))
+	for _, item := range p.Items {
+		if item.Song != nil && item.Song.URI != "" {
+			songs = append(songs, *item.Song)
+		}
+	}
+	return songs
+}
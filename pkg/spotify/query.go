@@ -0,0 +1,68 @@
+package spotify
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Query is a search query. Besides free text, it supports the field filters offered by Spotify, which narrow down the
+// results without guests having to know their syntax.
+type Query struct {
+	Text   string
+	Artist string
+	Track  string
+	Album  string
+	Genre  string
+	// YearFrom and YearTo restrict the release year, both inclusive. If YearTo is not set, only YearFrom matches.
+	YearFrom uint
+	YearTo   uint
+}
+
+// Decade returns a copy of the query restricted to the decade starting with the given year, e.g. 1980 for the 80s.
+func (q Query) Decade(year uint) Query {
+	q.YearFrom = year
+	q.YearTo = year + 9
+	return q
+}
+
+// IsEmpty reports whether the query would match anything at all.
+func (q Query) IsEmpty() bool {
+	return q.String() == ""
+}
+
+// String encodes the query in Spotify's search syntax, e.g. `daft artist:"Daft Punk" year:2000-2009`.
+func (q Query) String() string {
+	parts := []string{}
+	if text := strings.TrimSpace(q.Text); text != "" {
+		parts = append(parts, text)
+	}
+	for _, f := range []struct{ field, value string }{
+		{"artist", q.Artist},
+		{"track", q.Track},
+		{"album", q.Album},
+		{"genre", q.Genre},
+	} {
+		if filter := queryFilter(f.field, f.value); filter != "" {
+			parts = append(parts, filter)
+		}
+	}
+	switch {
+	case q.YearFrom != 0 && q.YearTo > q.YearFrom:
+		parts = append(parts, fmt.Sprintf("year:%d-%d", q.YearFrom, q.YearTo))
+	case q.YearFrom != 0:
+		parts = append(parts, fmt.Sprintf("year:%d", q.YearFrom))
+	}
+	return strings.Join(parts, " ")
+}
+
+// queryFilter formats a single field filter, quoting values which consist of multiple words.
+func queryFilter(field string, value string) string {
+	value = strings.TrimSpace(strings.ReplaceAll(value, `"`, ""))
+	if value == "" {
+		return ""
+	}
+	if strings.ContainsAny(value, " \t") {
+		value = `"` + value + `"`
+	}
+	return field + ":" + value
+}
@@ -0,0 +1,75 @@
+package ui
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/a-h/templ"
+)
+
+var (
+	colorPattern = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+	fontPattern  = regexp.MustCompile(`^[A-Za-z][\w-]*( [A-Za-z][\w-]*)*$`)
+)
+
+// Event holds the branding of the event this instance is running for. It is passed into all page level components.
+type Event struct {
+	// Title is shown in the page header and used as the document title.
+	Title string
+	// Hosts are the people or organisation hosting the event, prominently shown in the page header.
+	Hosts string
+	// Subtitle is shown below the hosts, e.g. the date or venue.
+	Subtitle string
+	// Logo is the URL of an image shown next to the title.
+	Logo string
+	// PrimaryColor and SecondaryColor override the accent colors as hex codes, e.g. #d81b60.
+	PrimaryColor   string
+	SecondaryColor string
+	// Theme forces either the "light" or "dark" theme. The browser's preference is used if empty.
+	Theme string
+	// Font is the font family the hosts are shown in.
+	Font string
+	// FontURL is a stylesheet providing Font. If empty, the bundled fonts are used.
+	FontURL string
+	// Intro is a text shown above the search box.
+	Intro string
+	// Prompt is the placeholder shown in the search box.
+	Prompt string
+}
+
+// Validate checks whether all values can safely be used in styles.
+func (e *Event) Validate() error {
+	for _, color := range []string{e.PrimaryColor, e.SecondaryColor} {
+		if color != "" && !colorPattern.MatchString(color) {
+			return fmt.Errorf("color %q is not a hex code", color)
+		}
+	}
+	if e.Theme != "" && e.Theme != "light" && e.Theme != "dark" {
+		return fmt.Errorf("theme %q is neither light nor dark", e.Theme)
+	}
+	if e.Font != "" && !fontPattern.MatchString(e.Font) {
+		return fmt.Errorf("font %q contains unsupported characters", e.Font)
+	}
+	return nil
+}
+
+// accentStyle sets pico's color variables from the configured colors.
+func (e *Event) accentStyle() templ.SafeCSS {
+	style := &strings.Builder{}
+	if e.PrimaryColor != "" {
+		fmt.Fprintf(style, "--pico-primary: %[1]s; --pico-primary-background: %[1]s; --pico-primary-border: %[1]s; --pico-primary-hover: %[1]s; --pico-primary-hover-background: %[1]s;", e.PrimaryColor)
+	}
+	if e.SecondaryColor != "" {
+		fmt.Fprintf(style, "--pico-secondary: %[1]s; --pico-secondary-background: %[1]s; --pico-secondary-border: %[1]s; --pico-secondary-hover: %[1]s; --pico-secondary-hover-background: %[1]s;", e.SecondaryColor)
+	}
+	return templ.SafeCSS(style.String())
+}
+
+// fontStyle applies the configured font. The family is not quoted, as the style attribute would be escaped twice.
+func (e *Event) fontStyle() templ.SafeCSS {
+	if e.Font == "" {
+		return ""
+	}
+	return templ.SafeCSS(fmt.Sprintf("font-family: %s, sans-serif;", e.Font))
+}
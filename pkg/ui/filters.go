@@ -0,0 +1,30 @@
+package ui
+
+// Filter is a choice offered to narrow down searches.
+type Filter struct {
+	Value string
+	Label string
+}
+
+// Decades are offered as search filters. Their value is the first year of each decade.
+var Decades = []Filter{
+	{"1960", "60s"},
+	{"1970", "70s"},
+	{"1980", "80s"},
+	{"1990", "90s"},
+	{"2000", "2000s"},
+	{"2010", "2010s"},
+	{"2020", "2020s"},
+}
+
+// Genres are offered as search filters. Their value is the genre as known to Spotify.
+var Genres = []Filter{
+	{"pop", "Pop"},
+	{"rock", "Rock"},
+	{"hip-hop", "Hip-Hop"},
+	{"dance", "Dance"},
+	{"disco", "Disco"},
+	{"soul", "Soul"},
+	{"schlager", "Schlager"},
+	{"swing", "Swing"},
+}
@@ -0,0 +1,63 @@
+package ui
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/a-h/templ"
+
+	"github.com/debugloop/wunschkonzert/pkg/spotify"
+)
+
+// shelfLength is the number of artists, albums or playlists shown in search results.
+const shelfLength = 6
+
+// QueuedSong is an upcoming song along with the guest who requested it, if known.
+type QueuedSong struct {
+	Song  spotify.Song
+	Guest string
+}
+
+// artistNames returns the names of all artists in order.
+func artistNames(artists []spotify.Artist) []string {
+	names := make([]string, len(artists))
+	for i, artist := range artists {
+		names[i] = artist.Name
+	}
+	return names
+}
+
+// coverSrcset lists all cover images along with their widths, to let the browser choose the best fitting one.
+func coverSrcset(images []spotify.CoverImage) string {
+	candidates := make([]string, 0, len(images))
+	for _, img := range images {
+		if img.Width > 0 {
+			candidates = append(candidates, fmt.Sprintf("%s %dw", img.URL, img.Width))
+		}
+	}
+	return strings.Join(candidates, ", ")
+}
+
+// moreVals encodes the parameters requesting the next page of search results.
+func moreVals(offset uint) string {
+	vals, _ := json.Marshal(map[string]any{
+		"offset": offset,
+	})
+	return string(vals)
+}
+
+// progressAttrs describes the playback position as of rendering, which allows the browser to advance it.
+func progressAttrs(np *spotify.NowPlaying) templ.Attributes {
+	return templ.Attributes{
+		"data-progress-ms": fmt.Sprintf("%d", np.Progress(time.Now()).Milliseconds()),
+		"data-duration-ms": fmt.Sprintf("%d", np.Song.DurationMs),
+		"data-playing":     fmt.Sprintf("%t", np.Playing),
+	}
+}
+
+// htmxConfig makes htmx swap the fragments rendered for internal server errors, while other error responses are still
+// ignored as by default.
+const htmxConfig = `{"responseHandling":[{"code":"204","swap":false},{"code":"[23]..","swap":true},` +
+	`{"code":"500","swap":true,"error":true},{"code":"[45]..","swap":false,"error":true},{"code":"...","swap":false}]}`
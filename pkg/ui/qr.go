@@ -0,0 +1,28 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// qrPath encodes content as a QR code and returns it as a SVG path, along with the size of the code in modules. The
+// path includes the quiet zone around the code.
+func qrPath(content string) (int, string, error) {
+	code, err := qrcode.New(content, qrcode.Medium)
+	if err != nil {
+		return 0, "", err
+	}
+
+	bitmap := code.Bitmap()
+	path := &strings.Builder{}
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(path, "M%d %dh1v1h-1z", x, y)
+			}
+		}
+	}
+	return len(bitmap), path.String(), nil
+}